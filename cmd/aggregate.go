@@ -0,0 +1,71 @@
+package cmd
+
+// kahanSum is a compensated sum(Neumaier's variant of Kahan summation).
+// Running sums add and subtract values for as long as the input lasts, c keeps
+// the low order bits lost on each operation so the sum doesn't drift away.
+type kahanSum struct {
+	sum float64
+	c   float64
+}
+
+func (k *kahanSum) add(v float64) {
+	t := k.sum + v
+	if abs(k.sum) >= abs(v) {
+		k.c += (k.sum - t) + v
+	} else {
+		k.c += (v - t) + k.sum
+	}
+	k.sum = t
+}
+
+func (k kahanSum) value() float64 {
+	return k.sum + k.c
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// windowAgg keeps running aggregates of the events in a window, updated on every
+// enqueue and dequeue, so the avg of a minute is O(1) instead of summing the whole queue.
+// This is the "reusing the previous mean" from the Wikipedia note in sma.go.
+type windowAgg struct {
+	count      int
+	sum        kahanSum
+	sumSquares kahanSum
+	words      kahanSum
+}
+
+// add accounts for an event entering the window.
+func (a *windowAgg) add(e event) {
+	d := float64(e.Duration)
+	a.count++
+	a.sum.add(d)
+	a.sumSquares.add(d * d)
+	a.words.add(float64(e.NrWords))
+}
+
+// remove accounts for an event leaving the window.
+func (a *windowAgg) remove(e event) {
+	a.count--
+	if a.count <= 0 {
+		// an empty window sums exactly zero, drop whatever error is left.
+		*a = windowAgg{}
+		return
+	}
+	d := float64(e.Duration)
+	a.sum.add(-d)
+	a.sumSquares.add(-d * d)
+	a.words.add(-float64(e.NrWords))
+}
+
+// avg returns the avg duration, avoiding division by zero.
+func (a windowAgg) avg() float32 {
+	if a.count > 0 {
+		return float32(a.sum.value() / float64(a.count))
+	}
+	return 0
+}
@@ -9,12 +9,14 @@ import "time"
 // Just raised test coverage to 78.7% of statements!
 
 // BufFIFO represents a circular FIFO.
+// agg keeps running aggregates of queued events, so we never need to sum the queue.
 type BufFIFO struct {
 	queue []event
 	head  int
 	tail  int
 	size  int
 	cap   int
+	agg   windowAgg
 }
 
 // NewBufFIFO creates a new BufFIFO with min capacity of 16.
@@ -48,6 +50,7 @@ func (f *BufFIFO) Enqueue(item event) {
 	f.queue[f.tail] = item
 	f.tail = (f.tail + 1) % f.cap
 	f.size++
+	f.agg.add(item)
 }
 
 // Dequeue remove the head element.
@@ -55,26 +58,60 @@ func (f *BufFIFO) Dequeue() {
 	if f.size == 0 {
 		return
 	}
+	f.agg.remove(f.queue[f.head])
 	f.head = (f.head + 1) % f.cap
 	f.size--
 }
 
+// Evict drops events that are out of the time window for the given bucket.
+func (f *BufFIFO) Evict(currBucket time.Time, window time.Duration, onEvict func(e event)) {
+	f.dequeueBuffFIFOByTime(currBucket, window, onEvict)
+}
+
+// Avg returns the avg delivery time of queued events.
+func (f *BufFIFO) Avg() float32 {
+	return calculateAvgFromBuffFIFO(f)
+}
+
+// Aggregates returns the running aggregates of queued events.
+func (f *BufFIFO) Aggregates() windowAgg {
+	return f.agg
+}
+
+// Each calls fn for every queued event.
+func (f *BufFIFO) Each(fn func(e event)) {
+	for i := 0; i < f.size; i++ {
+		fn(f.queue[(f.head+i)%f.cap])
+	}
+}
+
+// Len returns how many events are queued.
+func (f *BufFIFO) Len() int {
+	return f.size
+}
+
+// Cap returns how many events fit before the buffer doubles.
+func (f *BufFIFO) Cap() int {
+	return f.cap
+}
+
 // dequeueBuffFIFOByTime dequeue all events that meet the given timewindow.
-func (fifo *BufFIFO) dequeueBuffFIFOByTime(currMinute time.Time, window int32) {
+// onEvict, when not nil, is called for each dequeued event.
+func (fifo *BufFIFO) dequeueBuffFIFOByTime(currMinute time.Time, windowDuration time.Duration, onEvict func(e event)) {
 	if fifo.size == 0 {
 		return
 	}
 
-	windowDuration := time.Minute * time.Duration(window)
-
 	for fifo.size > 0 {
 		event := fifo.queue[fifo.head]
 
 		// Check if the event is within the window.
 		if !event.Timestamp.Time.IsZero() && currMinute.Sub(event.Timestamp.Time) > windowDuration {
+			if onEvict != nil {
+				onEvict(event)
+			}
 			// Move the head forward and decrease the size.
-			fifo.head = (fifo.head + 1) % fifo.cap
-			fifo.size--
+			fifo.Dequeue()
 		} else {
 			// All remaining events are within the window.
 			break
@@ -83,20 +120,7 @@ func (fifo *BufFIFO) dequeueBuffFIFOByTime(currMinute time.Time, window int32) {
 }
 
 // calculateAvgFromBuffFIFO calculate avg from all queued elements.
+// This used to sum the whole buffer every minute, now it's O(1) from the running sum.
 func calculateAvgFromBuffFIFO(fifo *BufFIFO) float32 {
-	var sum float32
-	count := 0
-
-	for i := 0; i < fifo.size; i++ {
-		index := (fifo.head + i) % fifo.cap
-		if !fifo.queue[index].Timestamp.Time.IsZero() {
-			sum += float32(fifo.queue[index].Duration)
-			count++
-		}
-	}
-
-	if count > 0 {
-		return sum / float32(count)
-	}
-	return 0
+	return fifo.agg.avg()
 }
@@ -0,0 +1,396 @@
+package cmd
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrInvalidCheckpoint = errors.New("invalid --checkpoint")
+var ErrCheckpointMismatch = errors.New("checkpoint doesn't match this run")
+var ErrInterrupted = errors.New("interrupted")
+
+// checkpointVersion is bumped whenever checkpoint, or anything in it, changes shape.
+const checkpointVersion = 2
+
+// checkpoint is everything needed to carry on a run where it stopped, so the output
+// ends up byte identical to the one of a run that was never interrupted.
+// Window queues aren't saved as they are: each group keeps the events of its window,
+// and the queue, quantiles and stats are rebuilt by enqueuing them again.
+type checkpoint struct {
+	Version int
+	// Key tells the options of the run, a checkpoint can't be resumed with other ones.
+	Key string
+	// Input is how far the input was read, InputLines the new lines up to there,
+	// and InArray if it's a json array.
+	Input      int64
+	InputLines int
+	InArray    bool
+	// Output, LateEvents and DeadLetters are how many bytes were written to each file.
+	Output      int64
+	LateEvents  int64
+	DeadLetters int64
+	// counters behind the summary lines of the run.
+	FilterRead     int
+	FilterRejected int
+	LateCount      int
+	Skipped        int
+	// Events are the events kept by the server.
+	Events []event
+	State  streamerState
+}
+
+// streamerState is the state of a streamer between two events.
+type streamerState struct {
+	Pending    []event
+	MaxTs      time.Time
+	Started    bool
+	CurrBucket time.Time
+	LastTs     time.Time
+	Read       int
+	PrevTs     time.Time
+	Groups     []groupState
+}
+
+// groupState is the state of a group, its window events are enqueued again on restore.
+type groupState struct {
+	Key     string
+	Labels  []label
+	Start   time.Time
+	Window  []event
+	History []event
+	LastRow rowState
+	InGap   bool
+	// EMA is only set for the ema metric, the others have no state of their own.
+	EMA *emaState
+}
+
+// rowState is the part of an output row --gaps carry needs.
+type rowState struct {
+	Date            time.Time
+	AvgDeliveryTime float32
+	Fields          []field
+}
+
+// emaState is the state of an emaMetric.
+type emaState struct {
+	BucketSum   float64
+	BucketCount float64
+	Sum         float64
+	Count       float64
+	Last        time.Time
+}
+
+// validateCheckpoint checks --checkpoint can be used with the given input, outputs and sort mode.
+func validateCheckpoint(path string, every int, resume bool, input, output, lateEvents, deadLetters, sortMode string) error {
+	if path == "" {
+		if resume {
+			return fmt.Errorf("%w: --resume needs the --checkpoint to resume from", ErrInvalidCheckpoint)
+		}
+		return nil
+	}
+	switch {
+	case every < 0:
+		return fmt.Errorf("%w: --checkpoint-every can't be negative", ErrInvalidCheckpoint)
+	case input == stdioName:
+		return fmt.Errorf("%w: stdin can't be read again from where it stopped, use an input file", ErrInvalidCheckpoint)
+	case output == stdioName || lateEvents == stdioName || deadLetters == stdioName:
+		return fmt.Errorf("%w: rows written after the checkpoint are written again, use an output file", ErrInvalidCheckpoint)
+	case sortMode != "" && sortMode != sortNone:
+		return fmt.Errorf("%w: sorting reads the whole input first, there's no point to stop at", ErrInvalidCheckpoint)
+	}
+	return nil
+}
+
+// checkpointFiles are the input and output options of a run. Resuming with another input
+// would seek into the wrong file, and with another output format would mix formats in the output.
+type checkpointFiles struct {
+	// Input is the absolute path of the input file, the run can be resumed from another dir.
+	Input        string
+	InputOptions inputOptions
+	OutputFormat string
+	DateFormat   string
+	OnError      string
+}
+
+// checkpointKey identifies the options of a run, the filter expression and files included.
+func checkpointKey(opts smaOptions, filter string, files checkpointFiles) string {
+	opts.OnLate, opts.OnDrop = nil, nil
+	return fmt.Sprintf("%+v filter:%q files:%+v", opts, filter, files)
+}
+
+// state returns the streamer state, it's only consistent between two Push calls.
+func (s *streamer) state() streamerState {
+	st := streamerState{
+		Pending:    append([]event(nil), s.pending...),
+		MaxTs:      s.maxTs,
+		Started:    s.started,
+		CurrBucket: s.currBucket,
+		LastTs:     s.lastTs,
+		Read:       s.read,
+		PrevTs:     s.prevTs,
+	}
+	for _, g := range s.groups.sorted {
+		gs := groupState{
+			Key:     g.key,
+			Labels:  g.labels,
+			Start:   g.start,
+			History: g.history,
+			LastRow: rowState{Date: g.lastRow.Date, AvgDeliveryTime: g.lastRow.AvgDeliveryTime, Fields: g.lastRow.Fields},
+			InGap:   g.inGap,
+		}
+		g.queue.Each(func(e event) {
+			gs.Window = append(gs.Window, e)
+		})
+		if m, ok := g.metric.(*emaMetric); ok {
+			gs.EMA = &emaState{BucketSum: m.bucketSum, BucketCount: m.bucketCount, Sum: m.sum, Count: m.count, Last: m.last}
+		}
+		st.Groups = append(st.Groups, gs)
+	}
+	return st
+}
+
+// restore sets the streamer state to st, the streamer must be new.
+func (s *streamer) restore(st streamerState) {
+	s.pending = st.Pending
+	s.maxTs, s.started, s.currBucket = st.MaxTs, st.Started, st.CurrBucket
+	s.lastTs, s.read, s.prevTs = st.LastTs, st.Read, st.PrevTs
+
+	for _, gs := range st.Groups {
+		g := s.groups.newGroup(gs.Key, gs.Labels, gs.Start)
+		for _, e := range gs.Window {
+			g.queue.Enqueue(e)
+			if g.quantiles != nil {
+				g.quantiles.add(float64(e.Duration))
+			}
+			if g.stats != nil {
+				g.stats.add(e)
+			}
+		}
+		g.history = gs.History
+		g.inGap = gs.InGap
+		if !gs.LastRow.Date.IsZero() {
+			g.lastRow = output{
+				Date:            gs.LastRow.Date,
+				layout:          dateLayout(s.opts.Step),
+				Labels:          gs.Labels,
+				AvgDeliveryTime: gs.LastRow.AvgDeliveryTime,
+				Fields:          gs.LastRow.Fields,
+			}
+		}
+		if m, ok := g.metric.(*emaMetric); ok && gs.EMA != nil {
+			m.bucketSum, m.bucketCount, m.sum, m.count, m.last = gs.EMA.BucketSum, gs.EMA.BucketCount, gs.EMA.Sum, gs.EMA.Count, gs.EMA.Last
+		}
+		// groups were saved sorted by key.
+		s.groups.byKey[g.key] = g
+		s.groups.sorted = append(s.groups.sorted, g)
+	}
+}
+
+// writeCheckpoint saves cp to path. It's written to a temp file first and renamed,
+// so a crash while saving leaves the previous checkpoint in place.
+func writeCheckpoint(path string, cp *checkpoint) error {
+	cp.Version = checkpointVersion
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := gob.NewEncoder(f).Encode(cp); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// readCheckpoint loads the checkpoint at path, it returns nil when there's none yet.
+// key must match the one the checkpoint was saved with.
+func readCheckpoint(path, key string) (*checkpoint, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cp checkpoint
+	if err := gob.NewDecoder(f).Decode(&cp); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCheckpoint, err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("%w: version %d, expected %d", ErrCheckpointMismatch, cp.Version, checkpointVersion)
+	}
+	if cp.Key != key {
+		return nil, fmt.Errorf("%w: it was saved with other options or filter", ErrCheckpointMismatch)
+	}
+	return &cp, nil
+}
+
+// loadCheckpoint returns the checkpoint to carry on from with resume, or an empty one
+// with the key of this run when there's none to resume.
+func loadCheckpoint(path string, resume bool, key string) (*checkpoint, error) {
+	if resume {
+		cp, err := readCheckpoint(path, key)
+		if err != nil || cp != nil {
+			return cp, err
+		}
+	}
+	return &checkpoint{Key: key}, nil
+}
+
+// runCheckpoint saves the state of a run to --checkpoint, its fields are set as the run is.
+type runCheckpoint struct {
+	path string
+	// resumed is the checkpoint the run carried on from, an empty one for a new run.
+	resumed  *checkpoint
+	input    *jsonSource
+	followed *followReader
+	filter   *filterSource
+	policy   *errorPolicy
+	// deadLetters is nil without --on-error deadletter=<file>.
+	deadLetters *countingWriter
+	late        *lateOutput
+	out         io.Writer
+	output      *countingWriter
+	rows        rowWriter
+	streamer    *streamer
+}
+
+// save flushes what's written so far before saving, the checkpoint never points past it.
+func (c *runCheckpoint) save() error {
+	if err := c.rows.Flush(); err != nil {
+		return fmt.Errorf("%w: %v", ErrWriteOutput, err)
+	}
+	if err := syncFile(c.out); err != nil {
+		return fmt.Errorf("%w: %v", ErrWriteOutput, err)
+	}
+	input, lines := c.input.Offset(), c.input.Lines()
+	if c.followed != nil {
+		// the files before a rotation are gone, carry on from within the current one.
+		// Only blanks of them can be left behind Offset, their events were all read.
+		if start, before, ok := c.followed.rotation(); ok {
+			input, lines = max(input-start, 0), max(lines-c.resumed.InputLines-before, 0)
+		}
+	}
+	next := &checkpoint{
+		Key:        c.resumed.Key,
+		Input:      input,
+		InputLines: lines,
+		InArray:    c.input.inArray,
+		Output:     c.output.n,
+		LateCount:  c.late.count,
+		Skipped:    c.policy.skipped,
+		State:      c.streamer.state(),
+	}
+	if c.late.w != nil {
+		if err := c.late.w.Flush(); err != nil {
+			return fmt.Errorf("%w: %v", ErrWriteOutput, err)
+		}
+		next.LateEvents = c.late.written.n
+	}
+	if c.deadLetters != nil {
+		if err := c.policy.Flush(); err != nil {
+			return fmt.Errorf("%w: %v", ErrWriteOutput, err)
+		}
+		next.DeadLetters = c.deadLetters.n
+	}
+	if c.filter != nil {
+		next.FilterRead, next.FilterRejected = c.filter.read, c.filter.rejected
+	}
+	return writeCheckpoint(c.path, next)
+}
+
+// runStreamer pushes events from src to s until src is done, then finalizes the buckets left.
+// With save, it's called every `every` events, and when ctx is done instead of finalizing,
+// then stopped is true. A followed file returns io.EOF once ctx is done, so that's a stop too.
+func runStreamer(ctx context.Context, src eventSource, s *streamer, every int, save func() error) (stopped bool, err error) {
+	for n := 1; ; n++ {
+		if save != nil && ctx.Err() != nil {
+			return true, save()
+		}
+		e, err := src.Next()
+		if err == io.EOF {
+			if save != nil && ctx.Err() != nil {
+				return true, save()
+			}
+			return false, s.Close()
+		}
+		if err != nil {
+			return false, err
+		}
+		if err := s.Push(e); err != nil {
+			return false, err
+		}
+		if save != nil && every > 0 && n%every == 0 {
+			if err := save(); err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+// countingWriter counts the bytes written to w, the output offsets of a checkpoint.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// resumeOutput opens the given output file to carry on writing at offset,
+// whatever was written after the checkpoint is written again.
+func resumeOutput(filename string, offset int64) (*os.File, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// openOutput creates the given output file, or opens it to carry on at offset when
+// the run is checkpointed.
+func openOutput(filename string, checkpointed bool, offset int64, stdout io.Writer) (io.WriteCloser, error) {
+	if !checkpointed {
+		return createOutput(filename, stdout)
+	}
+	f, err := resumeOutput(filename, offset)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// syncFile flushes w to disk when it's a file, so a checkpoint never points past what's saved.
+func syncFile(w io.Writer) error {
+	if f, ok := w.(*os.File); ok {
+		return f.Sync()
+	}
+	return nil
+}
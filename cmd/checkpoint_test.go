@@ -0,0 +1,400 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// checkpointEvents returns events of a few clients over an hour, up to 90s out of order
+// and with a quiet stretch in the middle.
+func checkpointEvents() []event {
+	r := rand.New(rand.NewSource(42))
+	clients := []string{"airliberty", "taxi-eats", "easyjet"}
+	base := time.Date(2018, 12, 26, 18, 0, 0, 0, time.UTC)
+	var events []event
+	for i := 0; i < 300; i++ {
+		ts := base.Add(time.Duration(i)*12*time.Second - time.Duration(r.Intn(90))*time.Second)
+		if i > 150 {
+			ts = ts.Add(40 * time.Minute)
+		}
+		events = append(events, event{
+			Timestamp:  customTime{ts},
+			ClientName: clients[r.Intn(len(clients))],
+			NrWords:    r.Intn(100),
+			Duration:   r.Intn(60),
+		})
+	}
+	return events
+}
+
+func TestStreamerCheckpoint(t *testing.T) {
+	events := checkpointEvents()
+	base := smaOptions{Window: 10 * time.Minute, AllowedLateness: 2 * time.Minute}
+
+	tcs := []struct {
+		name string
+		opts func(o smaOptions) smaOptions
+	}{
+		{
+			name: "when calculating sma should carry on the same",
+			opts: func(o smaOptions) smaOptions { return o },
+		},
+		{
+			name: "when grouping with stats and quantiles should carry on the same",
+			opts: func(o smaOptions) smaOptions {
+				o.GroupBy, o.Stats, o.Quantiles = []string{"client_name"}, []string{"count", "min", "max", "stddev", "nr_words"}, []float64{0.5, 0.9}
+				return o
+			},
+		},
+		{
+			name: "when quantiles are approx should carry on the same",
+			opts: func(o smaOptions) smaOptions {
+				o.Quantiles, o.QuantileMode, o.QuantileAccuracy = []float64{0.99}, "approx", 0.01
+				return o
+			},
+		},
+		{
+			name: "when metric is ema should carry on the same",
+			opts: func(o smaOptions) smaOptions {
+				o.Metric, o.GroupBy = "ema", []string{"client_name"}
+				return o
+			},
+		},
+		{
+			name: "when gaps carry should carry on the same",
+			opts: func(o smaOptions) smaOptions {
+				o.Gaps, o.Stats = gapsCarry, []string{"count"}
+				return o
+			},
+		},
+		{
+			name: "when gaps null should carry on the same",
+			opts: func(o smaOptions) smaOptions {
+				o.Gaps, o.GroupBy = gapsNull, []string{"client_name"}
+				return o
+			},
+		},
+		{
+			name: "when correcting late events should carry on the same",
+			opts: func(o smaOptions) smaOptions {
+				o.AllowedLateness, o.Late = 30*time.Second, lateCorrect
+				return o
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts(base)
+			var want bytes.Buffer
+			wantRows := newWriterSink(&want)
+			s, err := newStreamerFor(engines["fifo"], opts, wantRows)
+			require.NoError(t, err)
+			stopped, err := runStreamer(context.Background(), &sliceSource{events: events}, s, 0, nil)
+			require.NoError(t, err)
+			require.False(t, stopped)
+			require.NoError(t, wantRows.Flush())
+
+			path := filepath.Join(t.TempDir(), "state.bin")
+			for _, k := range []int{1, 37, 151, 152, 299} {
+				var got bytes.Buffer
+				rows := newWriterSink(&got)
+				first, err := newStreamerFor(engines["buffifo"], opts, rows)
+				require.NoError(t, err)
+				for _, e := range events[:k] {
+					require.NoError(t, first.Push(e))
+				}
+				require.NoError(t, writeCheckpoint(path, &checkpoint{Key: "key", State: first.state()}))
+
+				cp, err := readCheckpoint(path, "key")
+				require.NoError(t, err)
+				second, err := newStreamerFor(engines["buffifo"], opts, rows)
+				require.NoError(t, err)
+				second.restore(cp.State)
+				for _, e := range events[k:] {
+					require.NoError(t, second.Push(e))
+				}
+				require.NoError(t, second.Close())
+				require.NoError(t, rows.Flush())
+				require.Equal(t, want.String(), got.String(), "stopped after %d events", k)
+			}
+		})
+	}
+}
+
+func TestReadCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.bin")
+	cp, err := readCheckpoint(path, "key")
+	require.NoError(t, err)
+	require.Nil(t, cp, "no checkpoint yet")
+
+	require.NoError(t, writeCheckpoint(path, &checkpoint{Key: "key", Input: 10}))
+	_, err = readCheckpoint(path, "other")
+	require.ErrorIs(t, err, ErrCheckpointMismatch)
+
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o644))
+	_, err = readCheckpoint(path, "key")
+	require.ErrorIs(t, err, ErrInvalidCheckpoint)
+}
+
+func TestJSONSourceResume(t *testing.T) {
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	var raw []json.RawMessage
+	require.NoError(t, json.Unmarshal(input, &raw))
+	var ndjson strings.Builder
+	for _, r := range raw {
+		ndjson.Write(r)
+		ndjson.WriteString("\n")
+	}
+
+	tcs := []struct {
+		name  string
+		input string
+	}{
+		{name: "when input is an array should resume after the last event", input: "  " + string(input)},
+		{name: "when input is ndjson should resume after the last event", input: ndjson.String()},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := readAll(newJSONSource(strings.NewReader(tc.input)))
+			require.NoError(t, err)
+
+			for k := 0; k <= len(want); k++ {
+				src := newJSONSource(strings.NewReader(tc.input))
+				for i := 0; i < k; i++ {
+					_, err := src.Next()
+					require.NoError(t, err)
+				}
+				offset := src.Offset()
+				resumed, err := resumeJSONSource(strings.NewReader(tc.input[offset:]), offset, src.Lines(), src.inArray)
+				require.NoError(t, err)
+				rest, err := readAll(resumed)
+				require.NoError(t, err)
+				require.Equal(t, want[k:], append([]event{}, rest...), "resumed after %d events", k)
+			}
+		})
+	}
+}
+
+func TestRootCheckpoint(t *testing.T) {
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	var raw []json.RawMessage
+	require.NoError(t, json.Unmarshal(input, &raw))
+
+	dir := t.TempDir()
+	path, output, state := filepath.Join(dir, "events.log"), filepath.Join(dir, "result.txt"), filepath.Join(dir, "state.bin")
+	appendFile(t, path, string(raw[0])+"\n"+string(raw[1])+"\n")
+
+	defer func() {
+		rootCmd.SetContext(context.Background())
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+		require.NoError(t, rootCmd.Flags().Set(FOLLOW_FLAG, "false"))
+		require.NoError(t, rootCmd.Flags().Set(CHECKPOINT_FLAG, ""))
+		require.NoError(t, rootCmd.Flags().Set(RESUME_FLAG, "false"))
+	}()
+
+	// the first run is stopped once the rows of the first events are written.
+	rootCmd.SetArgs([]string{"--input_file=" + path, "--output=" + output, "--window_size=10", "--follow", "--checkpoint=" + state})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- rootCmd.ExecuteContext(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		bs, _ := os.ReadFile(output)
+		return strings.Count(string(bs), "\n") == 5
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.FileExists(t, state)
+
+	// rows written after the checkpoint are written again.
+	appendFile(t, output, "a row of a run that crashed\n")
+	appendFile(t, path, string(raw[2])+"\n")
+
+	rootCmd.SetArgs([]string{"--input_file=" + path, "--output=" + output, "--window_size=10", "--follow=false", "--checkpoint=" + state, "--resume"})
+	require.NoError(t, rootCmd.ExecuteContext(context.Background()))
+
+	want, err := os.ReadFile("./testResult.txt")
+	require.NoError(t, err)
+	got, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, string(want), string(got))
+	require.NoFileExists(t, state, "a finished run removes its checkpoint")
+
+	// other options can't resume it.
+	require.NoError(t, writeCheckpoint(state, &checkpoint{Key: "other"}))
+	rootCmd.SetArgs([]string{"--input_file=" + path, "--output=" + output, "--window_size=5", "--checkpoint=" + state, "--resume"})
+	require.ErrorIs(t, rootCmd.ExecuteContext(context.Background()), ErrCheckpointMismatch)
+
+	rootCmd.SetArgs([]string{"--input_file=-", "--output=" + output, "--window_size=10", "--checkpoint=" + state, "--resume=false"})
+	require.ErrorIs(t, rootCmd.ExecuteContext(context.Background()), ErrInvalidCheckpoint)
+}
+
+func TestRootCheckpointRotation(t *testing.T) {
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	var raw []json.RawMessage
+	require.NoError(t, json.Unmarshal(input, &raw))
+	last := `{"timestamp":"2018-12-26 18:25:02.000000","translation_id":"5aa5b2f39f7254a75bb4","client_name":"taxi-eats","event_name":"translation_delivered","nr_words":10,"duration":40}`
+
+	dir := t.TempDir()
+	path, output, state := filepath.Join(dir, "events.log"), filepath.Join(dir, "result.txt"), filepath.Join(dir, "state.bin")
+	all, want := filepath.Join(dir, "all.log"), filepath.Join(dir, "want.txt")
+	appendFile(t, path, string(raw[0])+"\n"+string(raw[1])+"\n")
+	appendFile(t, all, string(raw[0])+"\n"+string(raw[1])+"\n"+string(raw[2])+"\n"+last+"\n")
+
+	defer func() {
+		rootCmd.SetContext(context.Background())
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+		require.NoError(t, rootCmd.Flags().Set(FOLLOW_FLAG, "false"))
+		require.NoError(t, rootCmd.Flags().Set(CHECKPOINT_FLAG, ""))
+		require.NoError(t, rootCmd.Flags().Set(RESUME_FLAG, "false"))
+	}()
+
+	// the first run is stopped once the rotated file was read.
+	rootCmd.SetArgs([]string{"--input_file=" + path, "--output=" + output, "--window_size=10", "--follow", "--checkpoint=" + state})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- rootCmd.ExecuteContext(ctx)
+	}()
+	countRows := func(n int) func() bool {
+		return func() bool {
+			bs, _ := os.ReadFile(output)
+			return strings.Count(string(bs), "\n") == n
+		}
+	}
+	require.Eventually(t, countRows(5), 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendFile(t, path, string(raw[2])+"\n")
+	// rows up to the minute of the event in the new file.
+	require.Eventually(t, countRows(13), 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.FileExists(t, state)
+
+	// the resumed run reads the new file from where the first one stopped in it.
+	appendFile(t, path, last+"\n")
+	rootCmd.SetArgs([]string{"--input_file=" + path, "--output=" + output, "--window_size=10", "--follow=false", "--checkpoint=" + state, "--resume"})
+	require.NoError(t, rootCmd.ExecuteContext(context.Background()))
+
+	rootCmd.SetArgs([]string{"--input_file=" + all, "--output=" + want, "--window_size=10", "--checkpoint=", "--resume=false"})
+	require.NoError(t, rootCmd.ExecuteContext(context.Background()))
+	wantRows, err := os.ReadFile(want)
+	require.NoError(t, err)
+	got, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, string(wantRows), string(got))
+}
+
+func TestRootCheckpointOtherFiles(t *testing.T) {
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	var raw []json.RawMessage
+	require.NoError(t, json.Unmarshal(input, &raw))
+
+	dir := t.TempDir()
+	path, other, output, state := filepath.Join(dir, "events.log"), filepath.Join(dir, "other.log"), filepath.Join(dir, "result.txt"), filepath.Join(dir, "state.bin")
+	appendFile(t, path, string(raw[0])+"\n"+string(raw[1])+"\n")
+	appendFile(t, other, string(raw[0])+"\n"+string(raw[1])+"\n")
+
+	defer func() {
+		rootCmd.SetContext(context.Background())
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+		require.NoError(t, rootCmd.Flags().Set(FOLLOW_FLAG, "false"))
+		require.NoError(t, rootCmd.Flags().Set(CHECKPOINT_FLAG, ""))
+		require.NoError(t, rootCmd.Flags().Set(RESUME_FLAG, "false"))
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FORMAT_FLAG, ""))
+		require.NoError(t, rootCmd.Flags().Set(DELIMITER_FLAG, ""))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FORMAT_FLAG, formatNDJSON))
+		require.NoError(t, rootCmd.Flags().Set(DATE_FORMAT_FLAG, ""))
+		require.NoError(t, rootCmd.Flags().Set(ON_ERROR_FLAG, onErrorFail))
+	}()
+
+	// a stopped run leaves a checkpoint to resume.
+	rootCmd.SetArgs([]string{"--input_file=" + path, "--output=" + output, "--window_size=10", "--follow", "--checkpoint=" + state})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- rootCmd.ExecuteContext(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		bs, _ := os.ReadFile(output)
+		return strings.Count(string(bs), "\n") == 5
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	saved, err := os.ReadFile(state)
+	require.NoError(t, err)
+	written, err := os.ReadFile(output)
+	require.NoError(t, err)
+
+	args := []string{"--output=" + output, "--window_size=10", "--follow=false", "--checkpoint=" + state, "--resume"}
+	tcs := []struct {
+		name string
+		args []string
+	}{
+		{name: "when input file changed should error", args: []string{"--input_file=" + other}},
+		{name: "when input format changed should error", args: []string{"--input_file=" + path, "--input-format=ndjson"}},
+		{name: "when delimiter changed should error", args: []string{"--input_file=" + path, "--delimiter=;"}},
+		{name: "when output format changed should error", args: []string{"--input_file=" + path, "--output-format=csv"}},
+		{name: "when date format changed should error", args: []string{"--input_file=" + path, "--date-format=rfc3339"}},
+		{name: "when on error policy changed should error", args: []string{"--input_file=" + path, "--on-error=skip"}},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(state, saved, 0o644))
+			rootCmd.SetArgs(append(append([]string{}, args...), tc.args...))
+			require.ErrorIs(t, rootCmd.ExecuteContext(context.Background()), ErrCheckpointMismatch)
+
+			// the output is left as it was.
+			got, err := os.ReadFile(output)
+			require.NoError(t, err)
+			require.Equal(t, string(written), string(got))
+			require.NoError(t, rootCmd.Flags().Set(INPUT_FORMAT_FLAG, ""))
+			require.NoError(t, rootCmd.Flags().Set(DELIMITER_FLAG, ""))
+			require.NoError(t, rootCmd.Flags().Set(OUTPUT_FORMAT_FLAG, formatNDJSON))
+			require.NoError(t, rootCmd.Flags().Set(DATE_FORMAT_FLAG, ""))
+			require.NoError(t, rootCmd.Flags().Set(ON_ERROR_FLAG, onErrorFail))
+		})
+	}
+}
+
+// readAll reads the events left in src.
+func readAll(src eventSource) ([]event, error) {
+	var events []event
+	for {
+		e, err := src.Next()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+}
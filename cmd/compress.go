@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var ErrUnknownCompression = errors.New("unknown compression")
+
+// compressions of the input, detected by their magic bytes, gzip is the only one we write.
+const (
+	compressionNone  = "none"
+	compressionGzip  = "gzip"
+	compressionZstd  = "zstd"
+	compressionBzip2 = "bzip2"
+)
+
+// compressionMagic are the bytes streams in the compression start with.
+type compressionMagic struct {
+	compression string
+	magic       []byte
+}
+
+// compressionMagics are the compressions we read, see detectCompression.
+var compressionMagics = []compressionMagic{
+	{compressionGzip, []byte{0x1f, 0x8b}},
+	{compressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	// "BZh" and the block size, from 1 to 9.
+	{compressionBzip2, []byte("BZh")},
+}
+
+// compressionExts are the extensions of compressed files, dropped to tell the input format
+// from the extension, e.g. events.ndjson.zst is ndjson.
+var compressionExts = map[string]bool{
+	".gz":   true,
+	".gzip": true,
+	".zst":  true,
+	".zstd": true,
+	".bz2":  true,
+}
+
+// detectCompression tells the compression of r from its first bytes, compressionNone when it isn't.
+// Bytes are only peeked, r still reads them.
+func detectCompression(r *bufio.Reader) (string, error) {
+	// like detectInputFormat, bytes are peeked one at a time, stdin might not have more yet.
+	candidates := compressionMagics
+	for i := 0; len(candidates) > 0; i++ {
+		b, err := peekByte(r, i)
+		if err == io.EOF {
+			return compressionNone, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		var next []compressionMagic
+		for _, c := range candidates {
+			if c.magic[i] != b {
+				continue
+			}
+			if i == len(c.magic)-1 {
+				return c.compression, nil
+			}
+			next = append(next, c)
+		}
+		candidates = next
+	}
+	return compressionNone, nil
+}
+
+// decompress returns a reader of r decompressed, r must be in the given compression.
+func decompress(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case compressionGzip:
+		// concatenated gzip files, e.g. from cat a.gz b.gz, are read as one.
+		return gzip.NewReader(r)
+	case compressionZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case compressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownCompression, compression)
+}
+
+// decompressedReader is a decompressed input, closing it closes the input too.
+type decompressedReader struct {
+	io.ReadCloser
+	input io.Closer
+}
+
+// Close closes the decompressor and the input.
+func (r decompressedReader) Close() error {
+	err := r.ReadCloser.Close()
+	if cerr := r.input.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// fileCompression tells the compression of the file at path.
+func fileCompression(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return detectCompression(bufio.NewReader(f))
+}
+
+// validateOutputCompression checks the --output-compression flag.
+func validateOutputCompression(compression string) error {
+	switch compression {
+	case "", compressionNone, compressionGzip:
+		return nil
+	}
+	return fmt.Errorf("%w %q, use one of: %s, %s", ErrUnknownCompression, compression, compressionNone, compressionGzip)
+}
+
+// gzipWriteCloser compresses what's written to w. With flush every write is flushed
+// to w, so a reader of a followed output gets rows as they come.
+type gzipWriteCloser struct {
+	gz    *gzip.Writer
+	w     io.WriteCloser
+	flush bool
+}
+
+// compressOutput returns w compressed with the given --output-compression.
+func compressOutput(w io.WriteCloser, compression string, flush bool) io.WriteCloser {
+	if compression != compressionGzip {
+		return w
+	}
+	return &gzipWriteCloser{gz: gzip.NewWriter(w), w: w, flush: flush}
+}
+
+func (c *gzipWriteCloser) Write(p []byte) (int, error) {
+	n, err := c.gz.Write(p)
+	if err != nil || !c.flush {
+		return n, err
+	}
+	return n, c.gz.Flush()
+}
+
+// Close ends the gzip stream and closes w.
+func (c *gzipWriteCloser) Close() error {
+	err := c.gz.Close()
+	if cerr := c.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectCompression(t *testing.T) {
+	tcs := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "when input is gzip should be gzip", input: "\x1f\x8b\x08", want: compressionGzip},
+		{name: "when input is zstd should be zstd", input: "\x28\xb5\x2f\xfd\x00", want: compressionZstd},
+		{name: "when input is bzip2 should be bzip2", input: "BZh9", want: compressionBzip2},
+		{name: "when input is json should be none", input: `[{"duration":1}]`, want: compressionNone},
+		{name: "when input only starts like a magic should be none", input: "BZ", want: compressionNone},
+		{name: "when input is csv starting like zstd should be none", input: "\x28\xb5,", want: compressionNone},
+		{name: "when input is empty should be none", want: compressionNone},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tc.input))
+			got, err := detectCompression(r)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+
+			rest, _ := io.ReadAll(r)
+			require.Equal(t, tc.input, string(rest), "detecting reads nothing")
+		})
+	}
+}
+
+func TestOpenInputAt(t *testing.T) {
+	input := mustReadFile(t, "./testInput.json")
+	dir := t.TempDir()
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write([]byte(input))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	var zst bytes.Buffer
+	zw, err := zstd.NewWriter(&zst)
+	require.NoError(t, err)
+	_, err = zw.Write([]byte(input))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tcs := []struct {
+		name     string
+		filename string
+		content  []byte
+		offset   int64
+	}{
+		{name: "when file isn't compressed should read it", filename: "events.json", content: []byte(input)},
+		{name: "when file isn't compressed should seek to offset", filename: "events.json", content: []byte(input), offset: 100},
+		{name: "when file is gzip should decompress it", filename: "events.json.gz", content: gz.Bytes()},
+		{name: "when file is gzip should decompress it up to offset", filename: "events.json.gz", content: gz.Bytes(), offset: 100},
+		{name: "when file is zstd should decompress it", filename: "events.ndjson.zst", content: zst.Bytes(), offset: 7},
+		{name: "when file is bzip2 should decompress it", filename: "./testInput.json.bz2"},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			path := tc.filename
+			if tc.content != nil {
+				path = filepath.Join(dir, tc.filename)
+				require.NoError(t, os.WriteFile(path, tc.content, 0o644))
+			}
+
+			r, err := openInputAt(path, nil, tc.offset)
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			require.Equal(t, input[tc.offset:], string(got))
+		})
+	}
+}
+
+func TestRootCompressed(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "result.txt.gz")
+	defer func() {
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_COMPRESSION_FLAG, compressionNone))
+	}()
+
+	rootCmd.SetArgs([]string{"--input_file=./testInput.json.bz2", "--output=" + output, "--window_size=10", "--output-compression=gzip"})
+	require.NoError(t, rootCmd.Execute())
+
+	f, err := os.Open(output)
+	require.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	got, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	require.Equal(t, mustReadFile(t, "./testResult.txt"), string(got))
+
+	rootCmd.SetArgs([]string{"--input_file=./testInput.json", "--output=" + output, "--output-compression=zip"})
+	require.ErrorIs(t, rootCmd.Execute(), ErrUnknownCompression)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidStep = errors.New("step must be a positive duration")
+
+// defaultDateLayout is the output date layout, "2006-01-02 15:04:05".
+const defaultDateLayout = "2006-01-02 15:04:05"
+
+// bucket truncates t to the start of its step, e.g. 18:11:08 is 18:11:00 for a 1m step.
+// Truncate works from the zero time, so steps of hours or days are aligned to UTC.
+func bucket(t time.Time, step time.Duration) time.Time {
+	return t.Truncate(step)
+}
+
+// dateLayout returns the output date layout for the given step, empty meaning defaultDateLayout.
+// Sub second steps need the milliseconds, otherwise every row of a second would look the same.
+func dateLayout(step time.Duration) string {
+	if step > 0 && step < time.Second {
+		return defaultDateLayout + ".000"
+	}
+	return ""
+}
+
+// parseDuration is time.ParseDuration also accepting days, e.g. 1d or 1d12h,
+// since days are the natural unit for trend lines. A sign is for the whole duration,
+// -1d2h is -26h like -1h30m is -90m.
+func parseDuration(s string) (time.Duration, error) {
+	signed := func(v string) bool { return strings.HasPrefix(v, "-") || strings.HasPrefix(v, "+") }
+	sign, unsigned := time.Duration(1), s
+	if signed(s) {
+		unsigned = s[1:]
+		if s[0] == '-' {
+			sign = -1
+		}
+	}
+	days, rest, found := strings.Cut(unsigned, "d")
+	if !found {
+		return time.ParseDuration(s)
+	}
+	n, err := strconv.Atoi(days)
+	if err != nil || signed(days) || signed(rest) {
+		// not a day prefix, or a sign inside, let time.ParseDuration tell what's wrong.
+		return time.ParseDuration(s)
+	}
+	d := time.Duration(n) * 24 * time.Hour
+	if rest != "" {
+		r, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, err
+		}
+		d += r
+	}
+	return sign * d, nil
+}
+
+// durationValue is a pflag.Value for durations accepting days.
+type durationValue time.Duration
+
+func (d *durationValue) Set(s string) error {
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) Type() string { return "duration" }
+
+func (d *durationValue) String() string { return time.Duration(*d).String() }
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDuration(t *testing.T) {
+	tcs := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{input: "10s", want: 10 * time.Second},
+		{input: "15m", want: 15 * time.Minute},
+		{input: "2h", want: 2 * time.Hour},
+		{input: "1d", want: 24 * time.Hour},
+		{input: "1d12h", want: 36 * time.Hour},
+		{input: "-1d2h", want: -26 * time.Hour},
+		{input: "+1d", want: 24 * time.Hour},
+		{input: "-1h30m", want: -90 * time.Minute},
+		{input: "1d-2h", wantErr: true},
+		{input: "--1d", wantErr: true},
+		{input: "d", wantErr: true},
+		{input: "1d12", wantErr: true},
+		{input: "ten minutes", wantErr: true},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := parseDuration(tc.input)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestStreamSMAStep(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+	at := func(s string) time.Time {
+		date, err := time.Parse("2006-01-02 15:04:05", s)
+		require.NoError(t, err)
+		return date
+	}
+
+	tcs := []struct {
+		name string
+		opts smaOptions
+		want map[string]float32
+	}{
+		{
+			name: "when step is 5m should emit a row every 5 minutes",
+			opts: smaOptions{Window: 10 * time.Minute, Step: 5 * time.Minute},
+			want: map[string]float32{
+				"2018-12-26 18:10:00": 0,
+				"2018-12-26 18:15:00": 20,
+				"2018-12-26 18:20:00": 25.5,
+				"2018-12-26 18:25:00": 42.5,
+			},
+		},
+		{
+			name: "when step is 1h and window 1d should emit hourly rows",
+			opts: smaOptions{Window: 24 * time.Hour, Step: time.Hour},
+			want: map[string]float32{
+				"2018-12-26 18:00:00": 0,
+				"2018-12-26 19:00:00": 35,
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := collectSMA(&sliceSource{events: events}, engines["fifo"], tc.opts)
+			require.NoError(t, err)
+			require.Len(t, got, len(tc.want))
+			for date, want := range tc.want {
+				require.Equal(t, want, got[resultKey{Date: at(date)}].AvgDeliveryTime, date)
+			}
+		})
+	}
+}
+
+func TestSubSecondStepDateLayout(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+
+	var rows []output
+	err = StreamSMA(&sliceSource{events: events[:1]}, engines["fifo"], smaOptions{
+		Window: time.Second,
+		Step:   500 * time.Millisecond,
+	}, funcSink(func(row output) error {
+		rows = append(rows, row)
+		return nil
+	}))
+	require.NoError(t, err)
+
+	bs, err := json.Marshal(rows[0])
+	require.NoError(t, err)
+	require.Equal(t, `{"date":"2018-12-26 18:11:08.500","average_delivery_time":0}`, string(bs))
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrUnknownEngine = errors.New("unknown engine")
+var ErrUnboundedEngine = errors.New("engine keeps every event, it can't run for long")
+
+// windowQueue is the window state an engine keeps between minutes.
+// Every sma engine is a different way of holding the events inside the window.
+type windowQueue interface {
+	// Enqueue adds a new event to the window.
+	Enqueue(item event)
+	// Evict drops events that are out of the time window for the given bucket,
+	// onEvict, when not nil, is called for each one of them.
+	Evict(currBucket time.Time, window time.Duration, onEvict func(e event))
+	// Avg returns the avg delivery time of events in the window.
+	Avg() float32
+	// Aggregates returns count, sums and sum of squares of events in the window.
+	Aggregates() windowAgg
+	// Each calls fn for every event in the window, from the oldest one.
+	Each(fn func(e event))
+}
+
+// engines is the registry of sma engines selectable with --engine.
+// Check benchmarksection.md to help choosing one:
+// fifo is the fastest, buffifo is the memory optimized circular buffer
+// and naive is kept as a baseline. A new engine is one more entry here.
+var engines = map[string]func() windowQueue{
+	"naive":   func() windowQueue { return &naiveQueue{} },
+	"fifo":    func() windowQueue { return NewFIFO() },
+	"buffifo": func() windowQueue { return NewBufFIFO(16) },
+}
+
+// unboundedEngines keep every event they were given, their memory only grows.
+var unboundedEngines = map[string]bool{"naive": true}
+
+// longRunningQueueFactory is engineQueueFactory for runs that don't end, e.g. --follow and serve,
+// unbounded engines aren't one of them.
+func longRunningQueueFactory(name string) (func() windowQueue, error) {
+	if unboundedEngines[name] {
+		return nil, fmt.Errorf("%w: %s, use fifo or buffifo", ErrUnboundedEngine, name)
+	}
+	return engineQueueFactory(name)
+}
+
+// engineQueueFactory returns the window queue constructor of the engine with the given name.
+func engineQueueFactory(name string) (func() windowQueue, error) {
+	newQueue, ok := engines[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, use one of: %s", ErrUnknownEngine, name, engineNames())
+	}
+	return newQueue, nil
+}
+
+// engineNames lists registered engines in alphabetical order.
+func engineNames() string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
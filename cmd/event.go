@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidEvent = errors.New("invalid event")
+
+// fieldError is an event field with an invalid value.
+type fieldError struct {
+	field string
+	err   error
+}
+
+func (e *fieldError) Error() string {
+	return e.field + ": " + e.err.Error()
+}
+
+// Unwrap makes errors.Is(err, ErrInvalidEvent) work.
+func (e *fieldError) Unwrap() error {
+	return e.err
+}
+
+// eventError is an event that couldn't be read, and where it is in the input.
+type eventError struct {
+	// file is the input file, empty for stdin.
+	file string
+	// line is the line(starting at 1) the event starts at, 0 when it isn't known.
+	line   int
+	offset int64
+	// field is the invalid field, empty when the whole event is, e.g. broken json.
+	field string
+	err   error
+	// record is the event as it is in the input.
+	record []byte
+	// fatal tells the input can't be read past the error, e.g. a broken json array.
+	fatal bool
+}
+
+// newEventError tells where err, about the given record, happened.
+func newEventError(err error, line int, offset int64, record []byte) *eventError {
+	e := &eventError{line: line, offset: offset, err: err, record: record}
+	var fe *fieldError
+	if errors.As(err, &fe) {
+		e.field, e.err = fe.field, fe.err
+	}
+	return e
+}
+
+func (e *eventError) Error() string {
+	var b strings.Builder
+	if e.file != "" {
+		b.WriteString(e.file + ": ")
+	}
+	if e.line > 0 {
+		fmt.Fprintf(&b, "line %d, ", e.line)
+	}
+	fmt.Fprintf(&b, "byte %d: ", e.offset)
+	if e.field != "" {
+		b.WriteString(e.field + ": ")
+	}
+	b.WriteString(e.err.Error())
+	return b.String()
+}
+
+// Unwrap returns the reason, an eventError is always an ErrInvalidEvent too, see Is.
+func (e *eventError) Unwrap() error {
+	return e.err
+}
+
+// Is makes errors.Is(err, ErrInvalidEvent) work for broken json too.
+func (e *eventError) Is(target error) bool {
+	return target == ErrInvalidEvent
+}
+
+// event represents a translation event.
+type event struct {
+	Timestamp      customTime `json:"timestamp"`
+	TranslationID  string     `json:"translation_id"`
+	SourceLanguage string     `json:"source_language"`
+	TargetLanguage string     `json:"target_language"`
+	ClientName     string     `json:"client_name"`
+	EventName      string     `json:"event_name"`
+	NrWords        int        `json:"nr_words"`
+	Duration       int        `json:"duration"`
+	// Extra keeps the fields we don't know about instead of silently dropping them.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// eventFields are the json fields mapped into the event struct.
+var eventFields = map[string]bool{
+	"timestamp":       true,
+	"translation_id":  true,
+	"source_language": true,
+	"target_language": true,
+	"client_name":     true,
+	"event_name":      true,
+	"nr_words":        true,
+	"duration":        true,
+}
+
+// UnmarshalJSON is a custom unmarshaller for the type event.
+// Known fields are decoded as usual, unknown ones go into Extra,
+// and the event is validated before being handed to the engines.
+func (e *event) UnmarshalJSON(data []byte) error {
+	// alias has no methods, so this won't call UnmarshalJSON again.
+	type alias event
+	var a alias
+	if err := json.Unmarshal(data, &a); err != nil {
+		// tell which field is wrong.
+		var typeErr *json.UnmarshalTypeError
+		var timeErr *time.ParseError
+		switch {
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			return &fieldError{field: typeErr.Field, err: fmt.Errorf("%w: got a %s, expected %s", ErrInvalidEvent, typeErr.Value, typeErr.Type)}
+		case errors.As(err, &timeErr):
+			return &fieldError{field: "timestamp", err: fmt.Errorf("%w: %v", ErrInvalidEvent, err)}
+		}
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		if eventFields[k] {
+			continue
+		}
+		if a.Extra == nil {
+			a.Extra = make(map[string]json.RawMessage)
+		}
+		a.Extra[k] = v
+	}
+
+	*e = event(a)
+	return e.validate()
+}
+
+// MarshalJSON writes the event like it was read, Extra fields after the known ones.
+func (e event) MarshalJSON() ([]byte, error) {
+	type alias event
+	bs, err := json.Marshal(alias(e))
+	if err != nil || len(e.Extra) == 0 {
+		return bs, err
+	}
+
+	names := make([]string, 0, len(e.Extra))
+	for name := range e.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bytes.NewBuffer(bs[:len(bs)-1])
+	for _, name := range names {
+		buf.WriteByte(',')
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(e.Extra[name])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// validate checks the event has what engines need to calculate the sma.
+func (e event) validate() error {
+	if e.Timestamp.IsZero() {
+		return &fieldError{field: "timestamp", err: fmt.Errorf("%w: missing timestamp", ErrInvalidEvent)}
+	}
+	if e.Duration < 0 {
+		return &fieldError{field: "duration", err: fmt.Errorf("%w: negative duration %d", ErrInvalidEvent, e.Duration)}
+	}
+	if e.NrWords < 0 {
+		return &fieldError{field: "nr_words", err: fmt.Errorf("%w: negative nr_words %d", ErrInvalidEvent, e.NrWords)}
+	}
+	return nil
+}
+
+// field returns the value of the given json field as a string,
+// looking into Extra for fields outside the schema.
+func (e event) field(name string) (string, bool) {
+	switch name {
+	case "timestamp":
+		return e.Timestamp.Format(timestampLayout), true
+	case "translation_id":
+		return e.TranslationID, true
+	case "source_language":
+		return e.SourceLanguage, true
+	case "target_language":
+		return e.TargetLanguage, true
+	case "client_name":
+		return e.ClientName, true
+	case "event_name":
+		return e.EventName, true
+	case "nr_words":
+		return strconv.Itoa(e.NrWords), true
+	case "duration":
+		return strconv.Itoa(e.Duration), true
+	}
+
+	raw, ok := e.Extra[name]
+	if !ok {
+		return "", false
+	}
+	// strings are unquoted, anything else is kept as raw json.
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	return string(raw), true
+}
+
+// number returns the value of the given json field as a number,
+// it's false when the field is missing or isn't numeric.
+func (e event) number(name string) (float64, bool) {
+	switch name {
+	case "nr_words":
+		return float64(e.NrWords), true
+	case "duration":
+		return float64(e.Duration), true
+	}
+	s, ok := e.field(name)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return n, err == nil
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventUnmarshalJSON(t *testing.T) {
+	tcs := []struct {
+		name    string
+		input   string
+		wantErr error
+		check   func(t *testing.T, e event)
+	}{
+		{
+			name: "when all fields are present should decode full schema",
+			input: `{"timestamp": "2018-12-26 18:11:08.509654","translation_id": "5aa5b2f39f7254a75aa5",
+				"source_language": "en","target_language": "fr","client_name": "airliberty",
+				"event_name": "translation_delivered","nr_words": 30, "duration": 20}`,
+			check: func(t *testing.T, e event) {
+				tt, err := parseTime("2018-12-26 18:11:08.509654")
+				require.NoError(t, err)
+				require.Equal(t, event{
+					Timestamp:      customTime{tt},
+					TranslationID:  "5aa5b2f39f7254a75aa5",
+					SourceLanguage: "en",
+					TargetLanguage: "fr",
+					ClientName:     "airliberty",
+					EventName:      "translation_delivered",
+					NrWords:        30,
+					Duration:       20,
+				}, e)
+			},
+		},
+		{
+			name:  "when unknown fields are present should keep them in extra",
+			input: `{"timestamp": "2018-12-26 18:11:08.509654", "duration": 20, "region": "eu", "retries": 2}`,
+			check: func(t *testing.T, e event) {
+				require.Equal(t, map[string]json.RawMessage{
+					"region":  json.RawMessage(`"eu"`),
+					"retries": json.RawMessage(`2`),
+				}, e.Extra)
+			},
+		},
+		{
+			name:    "when timestamp is missing should error",
+			input:   `{"duration": 20}`,
+			wantErr: ErrInvalidEvent,
+		},
+		{
+			name:    "when duration is negative should error",
+			input:   `{"timestamp": "2018-12-26 18:11:08.509654", "duration": -1}`,
+			wantErr: ErrInvalidEvent,
+		},
+		{
+			name:    "when nr_words is negative should error",
+			input:   `{"timestamp": "2018-12-26 18:11:08.509654", "nr_words": -1}`,
+			wantErr: ErrInvalidEvent,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var e event
+			err := json.Unmarshal([]byte(tc.input), &e)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			tc.check(t, e)
+		})
+	}
+}
+
+func TestEventMarshalJSON(t *testing.T) {
+	input := `{"timestamp":"2018-12-26 18:11:08.509654","translation_id":"5aa5b2f39f7254a75aa5","source_language":"en",` +
+		`"target_language":"fr","client_name":"airliberty","event_name":"translation_delivered","nr_words":30,"duration":20,` +
+		`"region":"eu","retries":2}`
+	var e event
+	require.NoError(t, json.Unmarshal([]byte(input), &e))
+
+	bs, err := json.Marshal(e)
+	require.NoError(t, err)
+	require.Equal(t, input, string(bs))
+}
+
+func TestEventFieldError(t *testing.T) {
+	tcs := []struct {
+		name      string
+		input     string
+		wantField string
+	}{
+		{name: "when timestamp is missing should tell timestamp", input: `{"duration": 20}`, wantField: "timestamp"},
+		{name: "when timestamp can't be parsed should tell timestamp", input: `{"timestamp": "26/12/2018"}`, wantField: "timestamp"},
+		{name: "when duration isn't a number should tell duration", input: `{"timestamp": "2018-12-26 18:11:08.509654", "duration": "20"}`, wantField: "duration"},
+		{name: "when nr_words is negative should tell nr_words", input: `{"timestamp": "2018-12-26 18:11:08.509654", "nr_words": -1}`, wantField: "nr_words"},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var e event
+			err := json.Unmarshal([]byte(tc.input), &e)
+			require.ErrorIs(t, err, ErrInvalidEvent)
+			var fieldErr *fieldError
+			require.ErrorAs(t, err, &fieldErr)
+			require.Equal(t, tc.wantField, fieldErr.field)
+		})
+	}
+}
@@ -3,18 +3,21 @@ package cmd
 import "time"
 
 // FIFO define our FIFO type.
+// agg keeps running aggregates of queued events, so we never need to sum the queue.
 type FIFO struct {
 	queue []event
+	agg   windowAgg
 }
 
 // NewFIFO creates a new FIFO.
 func NewFIFO() *FIFO {
-	return &FIFO{make([]event, 0)}
+	return &FIFO{queue: make([]event, 0)}
 }
 
 // Enqueue add an item to FIFO.
 func (f *FIFO) Enqueue(item event) {
 	f.queue = append(f.queue, item)
+	f.agg.add(item)
 }
 
 // Dequeue remove 'head' of FIFO.
@@ -22,34 +25,55 @@ func (f *FIFO) Dequeue() {
 	if len(f.queue) == 0 {
 		return
 	}
+	f.agg.remove(f.queue[0])
 	f.queue = f.queue[1:]
 }
 
-// dequeueByTime is a dequeue process that will happen as long as events inside FIFO
-// have timestamp Xmin 'smaller' then the minute that is being considere.
-func dequeueByTime(currMinute time.Time, fifo *FIFO, window int32) []event {
-	// we cant iterate over fifo.queue and remove, so we iterate over a copy.
-	auxQueue := fifo.queue
-	for _, event := range auxQueue {
-		// Remove events from the queue that are older than X minutes from the current minute.
-		if currMinute.Sub(event.Timestamp.Time) > time.Minute*time.Duration(window) {
-			// remove event from queue.
-			fifo.Dequeue()
+// Evict drops events that are out of the time window for the given bucket.
+// It stops at the first event inside the window, since events are ordered by timestamp.
+func (f *FIFO) Evict(currBucket time.Time, window time.Duration, onEvict func(e event)) {
+	for len(f.queue) > 0 && currBucket.Sub(f.queue[0].Timestamp.Time) > window {
+		if onEvict != nil {
+			onEvict(f.queue[0])
 		}
+		f.Dequeue()
+	}
+}
+
+// Avg returns the avg delivery time of queued events.
+func (f *FIFO) Avg() float32 {
+	return calculateAvg(f)
+}
+
+// Aggregates returns the running aggregates of queued events.
+func (f *FIFO) Aggregates() windowAgg {
+	return f.agg
+}
+
+// Each calls fn for every queued event.
+func (f *FIFO) Each(fn func(e event)) {
+	for _, e := range f.queue {
+		fn(e)
 	}
+}
 
+// Len returns how many events are queued.
+func (f *FIFO) Len() int {
+	return len(f.queue)
+}
+
+// dequeueByTime is a dequeue process that will happen as long as events inside FIFO
+// have timestamp Xmin 'smaller' then the minute that is being considere.
+// We used to go over a copy of the whole queue here, but with the running sums
+// that was the only O(window) step left, so now we only look at the head:
+// events are ordered, once the head is inside the window all others are too.
+func dequeueByTime(currMinute time.Time, fifo *FIFO, window int32) []event {
+	fifo.Evict(currMinute, time.Minute*time.Duration(window), nil)
 	return fifo.queue
 }
 
 // calculates avg for all elements in FIFO.
+// This used to sum the whole queue every minute, now it's O(1) from the running sum.
 func calculateAvg(fifo *FIFO) float32 {
-	var sum float32
-	for _, event := range fifo.queue {
-		sum += float32(event.Duration)
-	}
-	// avoid division by zero!
-	if len(fifo.queue) > 0 {
-		return (sum) / float32(len(fifo.queue))
-	}
-	return 0
+	return fifo.agg.avg()
 }
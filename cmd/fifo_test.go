@@ -52,3 +52,49 @@ func TestFIFODequeueEmpty(t *testing.T) {
 	fifo.Dequeue()
 	require.Len(t, fifo.queue, 0)
 }
+
+func TestFIFORunningAggregates(t *testing.T) {
+	fifo := NewFIFO()
+	buf := NewBufFIFO(16)
+	for i, d := range []int{10, 20, 30, 40} {
+		item := event{Timestamp: customTime{e.Timestamp.Add(time.Duration(i) * time.Minute)}, Duration: d, NrWords: 1}
+		fifo.Enqueue(item)
+		buf.Enqueue(item)
+	}
+	require.Equal(t, float32(25), calculateAvg(fifo))
+	require.Equal(t, float32(25), calculateAvgFromBuffFIFO(buf))
+
+	fifo.Dequeue()
+	buf.Dequeue()
+	for _, agg := range []windowAgg{fifo.Aggregates(), buf.Aggregates()} {
+		require.Equal(t, 3, agg.count)
+		require.Equal(t, float64(90), agg.sum.value())
+		require.Equal(t, float64(2900), agg.sumSquares.value())
+		require.Equal(t, float64(3), agg.words.value())
+	}
+	require.Equal(t, float32(30), calculateAvg(fifo))
+	require.Equal(t, float32(30), calculateAvgFromBuffFIFO(buf))
+
+	// empty queues go back to exactly zero.
+	for i := 0; i < 3; i++ {
+		fifo.Dequeue()
+	}
+	require.Equal(t, windowAgg{}, fifo.Aggregates())
+	require.Equal(t, float32(0), calculateAvg(fifo))
+}
+
+func TestKahanSum(t *testing.T) {
+	var naive float64
+	var k kahanSum
+	// a big value coming and going while small ones stay, like a long running window.
+	for i := 0; i < 1000000; i++ {
+		naive += 1e8
+		k.add(1e8)
+		naive += 0.1
+		k.add(0.1)
+		naive -= 1e8
+		k.add(-1e8)
+	}
+	require.InDelta(t, 100000, k.value(), 1e-6)
+	require.NotEqual(t, float64(100000), naive)
+}
@@ -1,23 +1,15 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/gob"
 	"encoding/json"
-	"fmt"
-	"log"
+	"io"
 	"os"
-	"sort"
 	"strings"
 	"time"
 )
 
-// event represents a translation event.
-type event struct {
-	Timestamp customTime `json:"timestamp"`
-	Duration  int        `json:"duration"`
-}
-
 // customTime represents an alias for time.
 type customTime struct {
 	time.Time
@@ -37,7 +29,6 @@ func (t *customTime) UnmarshalJSON(data []byte) error {
 	timeStr := strings.Trim(string(data), `"`)
 	tt, err := parseTime(timeStr)
 	if err != nil {
-		fmt.Println("Error parsing time:", err)
 		return err
 	}
 
@@ -45,14 +36,18 @@ func (t *customTime) UnmarshalJSON(data []byte) error {
 	return err
 }
 
-func parseTime(timeStr string) (time.Time, error) {
-	// Layout string corresponding to the input format.
-	layout := "2006-01-02 15:04:05.999999"
+// MarshalJSON writes the timestamp back in the input layout.
+func (t customTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Format(timestampLayout))
+}
+
+// timestampLayout is the layout of event timestamps in the input.
+const timestampLayout = "2006-01-02 15:04:05.999999"
 
+func parseTime(timeStr string) (time.Time, error) {
 	// Parse the time string.
-	parsedTime, err := time.Parse(layout, timeStr)
+	parsedTime, err := time.Parse(timestampLayout, timeStr)
 	if err != nil {
-		fmt.Println("Error parsing time:", err)
 		return time.Time{}, err
 	}
 
@@ -61,93 +56,154 @@ func parseTime(timeStr string) (time.Time, error) {
 }
 
 // parseInputFile opens the given input file and marshall into the event struct type.
+// A compressed file is decompressed on the fly.
 func parseInputFile(filename string) ([]event, error) {
 	var data []event
-	file, err := os.ReadFile(filename)
+	file, err := openInputAt(filename, nil, 0)
 	if err != nil {
-		fmt.Println(err)
 		return data, err
 	}
+	defer file.Close()
 
-	err = json.Unmarshal(file, &data)
+	err = json.NewDecoder(file).Decode(&data)
 	if err != nil {
-		fmt.Println(err)
 		return data, err
 	}
 
-	// Create a buffer to hold the binary data
-	var buffer bytes.Buffer
+	return data, nil
+}
 
-	// Create a new encoder
-	encoder := gob.NewEncoder(&buffer)
+// stdioName is the file name used to read from stdin or write to stdout.
+const stdioName = "-"
 
-	// Encode the slice of structs into the buffer
-	if err := encoder.Encode(data); err != nil {
-		log.Fatalf("Failed to encode: %v", err)
+// openInput opens the given input file, when filename is "-" stdin is used instead.
+func openInput(filename string, stdin io.Reader) (io.ReadCloser, error) {
+	if filename == stdioName {
+		return io.NopCloser(stdin), nil
 	}
-
-	return data, nil
+	return os.Open(filename)
 }
 
-// writeOutput write the final outputfile ordered by event timestamp.
-// result file will always live at root level.
-func writeOutput(data map[time.Time]output) error {
-	f, err := os.Create("./result.txt")
+// openInputAt opens the given input file like openInput, and skips to offset.
+// A gzip, zstd or bzip2 input, told by its first bytes, is decompressed while it's read,
+// offset is then in decompressed bytes: the input is decompressed up to there as we can't seek.
+func openInputAt(filename string, stdin io.Reader, offset int64) (io.ReadCloser, error) {
+	f, err := openInput(filename, stdin)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	compression, err := detectCompression(br)
+	if err != nil {
+		f.Close()
+		return nil, err
 	}
-	defer f.Close()
-
-	// This is needed due to when we range over a map we will get random order
-	// affcting the final output result file.
-	sorted := sortResultData(data)
 
-	for _, row := range sorted {
-		bs, err := json.Marshal(row)
-		if err != nil {
-			return err
+	if compression == compressionNone {
+		if offset == 0 {
+			return decompressedReader{ReadCloser: io.NopCloser(br), input: f}, nil
 		}
-
-		// we will append a new line at the end of each row for output readability.
-		bs = append(bs, []byte("\n")...)
-		_, err = f.Write(bs)
-		if err != nil {
-			return err
+		if _, err := f.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
 		}
+		return f, nil
 	}
 
-	return err
+	d, err := decompress(br, compression)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := decompressedReader{ReadCloser: d, input: f}
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
 }
 
-// sortResultData sorts ascendetly the input map by key
-// where key it the event timestamp, and returns an array of output.
-func sortResultData(data map[time.Time]output) []output {
-	var result []output
-
-	// first create slice from map.
-	for _, v := range data {
-		result = append(result, v)
+// createOutput creates the given output file, when filename is "-" stdout is used instead.
+func createOutput(filename string, stdout io.Writer) (io.WriteCloser, error) {
+	if filename == stdioName {
+		return nopWriteCloser{stdout}, nil
 	}
+	return os.Create(filename)
+}
 
-	// use builtin sort func.
-	sort.Slice(result, func(i, j int) bool {
-		return result[i].Date.Before(result[j].Date)
-	})
-
-	return result
+// nopWriteCloser wraps a writer we don't own, e.g. stdout, so closing it is a no-op.
+type nopWriteCloser struct {
+	io.Writer
 }
 
+// Close does nothing.
+func (nopWriteCloser) Close() error { return nil }
+
 // MarshalJSON is a custom marshaller for the type output.
-// This is need to remove the 'Z' from time format.
+// This is need to remove the 'Z' from time format,
+// and to place group labels between date and avg, e.g.:
+// {"date":"2018-12-26 18:11:00","client_name":"airliberty","average_delivery_time":20}
 func (t output) MarshalJSON() ([]byte, error) {
-	customStruct := struct {
-		// TODO: fix, not really json tags here.
-		Date            string  `json:"date"`
-		AvgDeliveryTime float32 `json:"average_delivery_time"`
-	}{
-		// "2006-01-02 15:04:05" is the layout format.
-		Date:            t.Date.Format("2006-01-02 15:04:05"),
-		AvgDeliveryTime: t.AvgDeliveryTime,
-	}
-	return json.Marshal(customStruct)
+	var buf bytes.Buffer
+	if err := writeJSONField(&buf, "date", t.Date.Format(t.dateLayout())); err != nil {
+		return nil, err
+	}
+	for _, l := range t.Labels {
+		if err := writeJSONField(&buf, l.Name, l.Value); err != nil {
+			return nil, err
+		}
+	}
+	var avg any = t.AvgDeliveryTime
+	if t.null {
+		avg = nil
+	}
+	if err := writeJSONField(&buf, "average_delivery_time", avg); err != nil {
+		return nil, err
+	}
+	for _, f := range t.Fields {
+		value := f.Value
+		if t.null {
+			value = nil
+		}
+		if err := writeJSONField(&buf, f.Name, value); err != nil {
+			return nil, err
+		}
+	}
+	if t.correction {
+		if err := writeJSONField(&buf, "correction", true); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// dateLayout returns the layout of the row date.
+// "2006-01-02 15:04:05" is the layout format, unless the step needs a finer one or --date-format was given.
+func (t output) dateLayout() string {
+	if t.layout == "" {
+		return defaultDateLayout
+	}
+	return t.layout
+}
+
+// writeJSONField writes "name":value to buf, opening the object on the first field.
+func writeJSONField(buf *bytes.Buffer, name string, value any) error {
+	if buf.Len() == 0 {
+		buf.WriteByte('{')
+	} else {
+		buf.WriteByte(',')
+	}
+	bs, err := json.Marshal(name)
+	if err != nil {
+		return err
+	}
+	buf.Write(bs)
+	buf.WriteByte(':')
+	bs, err = json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buf.Write(bs)
+	return nil
 }
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNoInputFiles = errors.New("no input files")
+
+// expandInputs returns the files of --input_file: a comma separated list of paths, glob
+// patterns and directories. Globs and directories are expanded in name order, a directory
+// to the files in it with a known input extension, hidden ones and sub directories left out.
+func expandInputs(spec string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			files = append(files, name)
+		}
+	}
+
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches := []string{pattern}
+		if pattern != stdioName && strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrNoInputFiles, pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%w: %s matches no file", ErrNoInputFiles, pattern)
+			}
+		}
+
+		for _, name := range matches {
+			// a missing file is reported when it's opened, like a single one always was.
+			info, err := os.Stat(name)
+			if err != nil || !info.IsDir() {
+				add(name)
+				continue
+			}
+			inDir, err := dirInputs(name)
+			if err != nil {
+				return nil, err
+			}
+			if len(inDir) == 0 {
+				return nil, fmt.Errorf("%w: %s has no event files", ErrNoInputFiles, name)
+			}
+			for _, name := range inDir {
+				add(name)
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, ErrNoInputFiles
+	}
+	if len(files) > 1 && seen[stdioName] {
+		return nil, fmt.Errorf("%w: stdin can't be read along with files", ErrNoInputFiles)
+	}
+	return files, nil
+}
+
+// dirInputs returns the files in dir with a known input extension, compressed ones included.
+func dirInputs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if _, ok := extInputFormat(entry.Name()); ok {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// openEventSource opens the given input file as an eventSource, the format is detected
+// unless opts has one. Closing the returned closer closes the file.
+func openEventSource(filename string, opts inputOptions) (eventSource, io.Closer, error) {
+	f, err := openInputAt(filename, nil, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	in := bufio.NewReader(f)
+	if opts.Format == "" {
+		opts.Format, err = detectInputFormat(filename, in)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	src, err := newEventSource(in, opts, opts.Format)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return src, f, nil
+}
+
+// mergedFile is one of the files merged by filesSource.
+type mergedFile struct {
+	index  int
+	name   string
+	src    eventSource
+	closer io.Closer
+	head   event
+	// count is how many events were read.
+	count int
+}
+
+// next reads the file head, it returns false once the file is done.
+func (f *mergedFile) next() (bool, error) {
+	e, err := f.src.Next()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	f.head = e
+	f.count++
+	return true, nil
+}
+
+// inputHeap orders files by their head timestamp, files given first win ties,
+// like runHeap does for sorted runs.
+type inputHeap []*mergedFile
+
+func (h inputHeap) Len() int { return len(h) }
+func (h inputHeap) Less(i, j int) bool {
+	if !h[i].head.Timestamp.Equal(h[j].head.Timestamp.Time) {
+		return h[i].head.Timestamp.Before(h[j].head.Timestamp.Time)
+	}
+	return h[i].index < h[j].index
+}
+func (h inputHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *inputHeap) Push(x any)   { *h = append(*h, x.(*mergedFile)) }
+func (h *inputHeap) Pop() any {
+	old := *h
+	f := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return f
+}
+
+// filesSource k-way merges the events of several files, each sorted by timestamp, into a
+// single sorted stream. Memory is one event per file.
+// Errors of a file are returned like the ones of a single file, so --on-error handles them.
+type filesSource struct {
+	files []*mergedFile
+	heads inputHeap
+	// advance are the files whose head is still to be read, an error reading it
+	// is returned after the events before it.
+	advance []*mergedFile
+}
+
+// openFilesSource opens the given files to be merged, the policy handles their invalid events.
+func openFilesSource(filenames []string, opts inputOptions, policy *errorPolicy) (*filesSource, error) {
+	s := &filesSource{}
+	for i, name := range filenames {
+		src, closer, err := openEventSource(name, opts)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		src = &errorSource{src: src, file: name, policy: policy}
+		s.files = append(s.files, &mergedFile{index: i, name: name, src: src, closer: closer})
+	}
+	s.advance = append(s.advance, s.files...)
+	return s, nil
+}
+
+// Next returns the smallest head among files.
+func (s *filesSource) Next() (event, error) {
+	for len(s.advance) > 0 {
+		f := s.advance[0]
+		ok, err := f.next()
+		if err != nil {
+			return event{}, err
+		}
+		s.advance = s.advance[1:]
+		if ok {
+			heap.Push(&s.heads, f)
+		}
+	}
+	if len(s.heads) == 0 {
+		return event{}, io.EOF
+	}
+	f := heap.Pop(&s.heads).(*mergedFile)
+	s.advance = append(s.advance, f)
+	return f.head, nil
+}
+
+// summary writes how many events were read from each file.
+func (s *filesSource) summary(w io.Writer) {
+	for _, f := range s.files {
+		fmt.Fprintf(w, "%s: %d events\n", f.name, f.count)
+	}
+}
+
+// Close closes the files.
+func (s *filesSource) Close() error {
+	var errs []error
+	for _, f := range s.files {
+		errs = append(errs, f.closer.Close())
+	}
+	s.files, s.heads, s.advance = nil, nil, nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// splitTestInput writes the events of testInput.json to one file each in dir, the second
+// one as csv and the third one gzipped, and returns their names in name order.
+// events-a has the second event, so name order isn't time order.
+func splitTestInput(t *testing.T, dir string) []string {
+	lines := strings.Split(strings.TrimSpace(testCSV), "\n")
+
+	first := `{"timestamp":"2018-12-26 18:11:08.509654","translation_id":"5aa5b2f39f7254a75aa5","source_language":"en","target_language":"fr","client_name":"airliberty","event_name":"translation_delivered","nr_words":30,"duration":20}` + "\n"
+	second := lines[0] + "\n" + lines[2] + "\n"
+	var third bytes.Buffer
+	gw := gzip.NewWriter(&third)
+	_, err := gw.Write([]byte(`[{"timestamp":"2018-12-26 18:23:19.903159","translation_id":"5aa5b2f39f7254a75bb3","source_language":"en","target_language":"fr","client_name":"taxi-eats","event_name":"translation_delivered","nr_words":100,"duration":54}]`))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	files := map[string][]byte{
+		"events-b.ndjson":  []byte(first),
+		"events-a.csv":     []byte(second),
+		"events-c.json.gz": third.Bytes(),
+		".hidden.json":     []byte("not events"),
+		"notes.txt":        []byte("not events"),
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), content, 0o644))
+	}
+	return []string{
+		filepath.Join(dir, "events-a.csv"),
+		filepath.Join(dir, "events-b.ndjson"),
+		filepath.Join(dir, "events-c.json.gz"),
+	}
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	files := splitTestInput(t, dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "empty"), 0o755))
+
+	tcs := []struct {
+		name    string
+		spec    string
+		want    []string
+		wantErr error
+	}{
+		{name: "when spec is a file should be the file", spec: "events.json", want: []string{"events.json"}},
+		{name: "when spec is stdin should be stdin", spec: "-", want: []string{"-"}},
+		{name: "when spec is a list should keep its order", spec: files[2] + ", " + files[0], want: []string{files[2], files[0]}},
+		{name: "when spec is a glob should be the matches in name order", spec: filepath.Join(dir, "events-*"), want: files},
+		{name: "when spec is a directory should be its event files", spec: dir, want: files},
+		{name: "when files repeat should be read once", spec: files[0] + "," + dir, want: files},
+		{name: "when glob matches nothing should fail", spec: filepath.Join(dir, "*.tsv"), wantErr: ErrNoInputFiles},
+		{name: "when directory has no event files should fail", spec: filepath.Join(dir, "empty"), wantErr: ErrNoInputFiles},
+		{name: "when stdin is given along files should fail", spec: "-," + files[0], wantErr: ErrNoInputFiles},
+		{name: "when spec is empty should fail", spec: " , ", wantErr: ErrNoInputFiles},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := expandInputs(tc.spec)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestFilesSource(t *testing.T) {
+	dir := t.TempDir()
+	files := splitTestInput(t, dir)
+	want, err := readAll(newJSONSource(strings.NewReader(mustReadFile(t, "./testInput.json"))))
+	require.NoError(t, err)
+
+	// the merge doesn't depend on the order files are given in.
+	s, err := openFilesSource([]string{files[2], files[1], files[0]}, inputOptions{}, newErrorPolicy(onErrorFail, nil))
+	require.NoError(t, err)
+	defer s.Close()
+	got, err := readAll(s)
+	require.NoError(t, err)
+	require.Len(t, got, len(want))
+	for i := range want {
+		require.Equal(t, want[i].Timestamp, got[i].Timestamp)
+		require.Equal(t, want[i].Duration, got[i].Duration)
+	}
+
+	// a broken file stops the merge, like a single one, unless --on-error skips its events.
+	broken := filepath.Join(dir, "broken.ndjson")
+	require.NoError(t, os.WriteFile(broken, []byte(`{"timestamp":"2018-12-26 18:12:00.000000","duration":7}`+"\n{\n"+`{"timestamp":"2018-12-26 18:30:00.000000","duration":9}`+"\n"), 0o644))
+	s, err = openFilesSource([]string{files[1], broken}, inputOptions{}, newErrorPolicy(onErrorFail, nil))
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = readAll(s)
+	require.ErrorIs(t, err, ErrInvalidEvent)
+
+	s, err = openFilesSource([]string{files[1], broken}, inputOptions{}, newErrorPolicy(onErrorSkip, nil))
+	require.NoError(t, err)
+	defer s.Close()
+	got, err = readAll(s)
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	require.Equal(t, 9, got[2].Duration)
+
+	var summary bytes.Buffer
+	s.summary(&summary)
+	require.Equal(t, files[1]+": 1 events\n"+broken+": 2 events\n", summary.String())
+}
+
+func TestRootFiles(t *testing.T) {
+	dir := t.TempDir()
+	splitTestInput(t, dir)
+	defer func() {
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+	}()
+
+	var stdout, stderr bytes.Buffer
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetErr(&stderr)
+	rootCmd.SetArgs([]string{"--input_file=" + dir, "--output=-", "--window_size=10"})
+	require.NoError(t, rootCmd.Execute())
+	require.Equal(t, mustReadFile(t, "./testResult.txt"), stdout.String())
+	require.Contains(t, stderr.String(), filepath.Join(dir, "events-a.csv")+": 1 events\n")
+
+	// an invalid event fails the run with --on-error fail, in any of the files.
+	broken := `{"timestamp":"2018-12-26 18:11:00.000000","duration":7}` + "\n" + `{"timestamp":"yesterday","duration":7}` + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "events-d.ndjson"), []byte(broken), 0o644))
+	rootCmd.SetArgs([]string{"--input_file=" + dir, "--output=-", "--window_size=10"})
+	require.ErrorIs(t, rootCmd.Execute(), ErrInvalidEvent)
+	require.NoError(t, os.Remove(filepath.Join(dir, "events-d.ndjson")))
+
+	rootCmd.SetArgs([]string{"--input_file=" + dir, "--output=-", "--follow"})
+	require.ErrorIs(t, rootCmd.Execute(), ErrInvalidFollow)
+}
@@ -0,0 +1,392 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// The --filter expression language is small on purpose, e.g.:
+//
+//	event_name == "translation_delivered" && client_name in ("airliberty", "taxi-eats")
+//	nr_words > 50 || !(source_language startswith "en")
+//
+// A comparison is always an event field on the left and a literal on the right.
+// Supported operators are: == != < <= > >= in, not in, startswith, and the boolean
+// operators && || ! with parentheses. Numbers are compared as numbers, strings as strings.
+
+// filter is a compiled --filter expression.
+type filter interface {
+	match(e event) bool
+}
+
+// compileFilter parses the expression once, so each event only walks the tree.
+func compileFilter(expr string) (filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return f, nil
+}
+
+// filterSource is an eventSource that only yields events matching the filter.
+// Rejected events are counted, so they can be reported instead of silently dropped.
+type filterSource struct {
+	src      eventSource
+	filter   filter
+	read     int
+	rejected int
+}
+
+// Next returns the next event matching the filter.
+func (s *filterSource) Next() (event, error) {
+	for {
+		e, err := s.src.Next()
+		if err != nil {
+			return e, err
+		}
+		s.read++
+		if s.filter.match(e) {
+			return e, nil
+		}
+		s.rejected++
+	}
+}
+
+// AST nodes.
+
+type andFilter struct{ left, right filter }
+
+func (f andFilter) match(e event) bool { return f.left.match(e) && f.right.match(e) }
+
+type orFilter struct{ left, right filter }
+
+func (f orFilter) match(e event) bool { return f.left.match(e) || f.right.match(e) }
+
+type notFilter struct{ inner filter }
+
+func (f notFilter) match(e event) bool { return !f.inner.match(e) }
+
+// literal is a string or number on the right side of a comparison.
+type literal struct {
+	text     string
+	num      float64
+	isNumber bool
+}
+
+// compareOps are the operators accepted by compareFilter.
+var compareOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// compareFilter compares an event field against a literal.
+type compareFilter struct {
+	field string
+	op    string
+	value literal
+}
+
+func (f compareFilter) match(e event) bool {
+	var cmp int
+	if f.value.isNumber {
+		n, ok := e.number(f.field)
+		if !ok {
+			return false
+		}
+		switch {
+		case n < f.value.num:
+			cmp = -1
+		case n > f.value.num:
+			cmp = 1
+		}
+	} else {
+		s, ok := e.field(f.field)
+		if !ok {
+			return f.op == "!="
+		}
+		cmp = strings.Compare(s, f.value.text)
+	}
+
+	switch f.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default: // ">="
+		return cmp >= 0
+	}
+}
+
+// inFilter checks if an event field is one of the listed literals.
+type inFilter struct {
+	field  string
+	values []literal
+}
+
+func (f inFilter) match(e event) bool {
+	for _, v := range f.values {
+		if (compareFilter{field: f.field, op: "==", value: v}).match(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixFilter checks if an event field starts with the given string.
+type prefixFilter struct {
+	field  string
+	prefix string
+}
+
+func (f prefixFilter) match(e event) bool {
+	s, ok := e.field(f.field)
+	return ok && strings.HasPrefix(s, f.prefix)
+}
+
+// Lexer.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// tokenize splits the expression in identifiers, literals and operators.
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			// find the closing quote, skipping escaped ones.
+			j := i + 1
+			for j < len(expr) && expr[j] != '"' {
+				if expr[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("%w: unterminated string at %d", ErrInvalidFilter, i)
+			}
+			s, err := strconv.Unquote(expr[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad string at %d: %v", ErrInvalidFilter, i, err)
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, pos: i})
+			i = j + 1
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(expr) && (expr[j] == '.' || unicode.IsDigit(rune(expr[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: expr[i:j], pos: i})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i + 1
+			for j < len(expr) && (expr[j] == '_' || expr[j] == '.' || unicode.IsLetter(rune(expr[j])) || unicode.IsDigit(rune(expr[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: expr[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", ","} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidFilter, c, i)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(expr)}), nil
+}
+
+// Parser, a plain recursive descent one:
+//
+//	or         := and ("||" and)*
+//	and        := unary ("&&" unary)*
+//	unary      := "!" unary | "(" or ")" | comparison
+//	comparison := field op literal | field ["not"] "in" "(" literal ("," literal)* ")" | field "startswith" string
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) peek() token { return p.tokens[p.pos] }
+
+func (p *filterParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) errorf(tok token, format string, args ...any) error {
+	return fmt.Errorf("%w: %s at %d", ErrInvalidFilter, fmt.Sprintf(format, args...), tok.pos)
+}
+
+func (p *filterParser) expectOp(op string) error {
+	if tok := p.next(); tok.kind != tokOp || tok.text != op {
+		return p.errorf(tok, "expected %q", op)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok.kind == tokOp && tok.text == "||"; tok = p.peek() {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok.kind == tokOp && tok.text == "&&"; tok = p.peek() {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filter, error) {
+	tok := p.peek()
+	if tok.kind == tokOp && tok.text == "!" {
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{inner}, nil
+	}
+	if tok.kind == tokOp && tok.text == "(" {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expectOp(")")
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filter, error) {
+	field := p.next()
+	if field.kind != tokIdent {
+		return nil, p.errorf(field, "expected event field, got %q", field.text)
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == tokIdent && op.text == "in":
+		return p.parseIn(field.text)
+	case op.kind == tokIdent && op.text == "not":
+		if tok := p.next(); tok.kind != tokIdent || tok.text != "in" {
+			return nil, p.errorf(tok, "expected \"in\" after \"not\"")
+		}
+		in, err := p.parseIn(field.text)
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{in}, nil
+	case op.kind == tokIdent && op.text == "startswith":
+		prefix := p.next()
+		if prefix.kind != tokString {
+			return nil, p.errorf(prefix, "startswith expects a string")
+		}
+		return prefixFilter{field: field.text, prefix: prefix.text}, nil
+	case op.kind == tokOp && compareOps[op.text]:
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return compareFilter{field: field.text, op: op.text, value: value}, nil
+	}
+	return nil, p.errorf(op, "expected operator after %q", field.text)
+}
+
+func (p *filterParser) parseIn(field string) (filter, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	f := inFilter{field: field}
+	for {
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		f.values = append(f.values, value)
+		tok := p.next()
+		if tok.kind == tokOp && tok.text == ")" {
+			return f, nil
+		}
+		if tok.kind != tokOp || tok.text != "," {
+			return nil, p.errorf(tok, "expected \",\" or \")\"")
+		}
+	}
+}
+
+func (p *filterParser) parseLiteral() (literal, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return literal{text: tok.text}, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return literal{}, p.errorf(tok, "bad number %q", tok.text)
+		}
+		return literal{text: tok.text, num: n, isNumber: true}, nil
+	}
+	return literal{}, p.errorf(tok, "expected string or number, got %q", tok.text)
+}
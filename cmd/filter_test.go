@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	e := event{
+		TranslationID:  "5aa5b2f39f7254a75aa5",
+		SourceLanguage: "en",
+		TargetLanguage: "fr",
+		ClientName:     "airliberty",
+		EventName:      "translation_delivered",
+		NrWords:        30,
+		Duration:       20,
+	}
+
+	tcs := []struct {
+		name    string
+		expr    string
+		want    bool
+		wantErr error
+	}{
+		{name: "when string equals should match", expr: `client_name == "airliberty"`, want: true},
+		{name: "when string differs should not match", expr: `client_name == "taxi-eats"`, want: false},
+		{name: "when number is greater should match", expr: `nr_words > 20`, want: true},
+		{name: "when number is not greater should not match", expr: `nr_words > 50`, want: false},
+		{name: "when number is equal should match", expr: `duration >= 20 && duration <= 20`, want: true},
+		{
+			name: "when value is in list should match",
+			expr: `event_name == "translation_delivered" && client_name in ("airliberty", "taxi-eats")`,
+			want: true,
+		},
+		{name: "when value is not in list should match", expr: `client_name not in ("taxi-eats")`, want: true},
+		{name: "when prefix matches should match", expr: `translation_id startswith "5aa5"`, want: true},
+		{name: "when negated should not match", expr: `!(source_language == "en")`, want: false},
+		{name: "when any side of or matches should match", expr: `nr_words > 50 || target_language != "de"`, want: true},
+		{name: "when and binds tighter than or should match", expr: `nr_words > 50 && duration > 50 || duration == 20`, want: true},
+		{name: "when field is missing should not match", expr: `region == "eu"`, want: false},
+		{name: "when operator is missing should error", expr: `client_name "airliberty"`, wantErr: ErrInvalidFilter},
+		{name: "when parenthesis is not closed should error", expr: `(nr_words > 50`, wantErr: ErrInvalidFilter},
+		{name: "when string is not terminated should error", expr: `client_name == "airliberty`, wantErr: ErrInvalidFilter},
+		{name: "when trailing tokens should error", expr: `nr_words > 50 50`, wantErr: ErrInvalidFilter},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := compileFilter(tc.expr)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, f.match(e))
+		})
+	}
+}
+
+func TestFilterSource(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+
+	f, err := compileFilter(`client_name == "airliberty"`)
+	require.NoError(t, err)
+	src := &filterSource{src: &sliceSource{events: events}, filter: f}
+
+	count := 0
+	for {
+		if _, err := src.Next(); err != nil {
+			break
+		}
+		count++
+	}
+	require.Equal(t, 2, count)
+	require.Equal(t, 3, src.read)
+	require.Equal(t, 1, src.rejected)
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+var ErrInvalidFollow = errors.New("invalid --follow")
+
+// followInterval is how often a followed file is checked for new lines once we reach its end.
+const followInterval = 250 * time.Millisecond
+
+// followReader reads a growing file like tail -f: at the end of the file it waits for
+// new lines instead of returning io.EOF, until ctx is done.
+// Only complete lines are returned, so a line being written when ctx is done is left
+// out instead of breaking the json decoder.
+// A truncated file is read again from the start, and a file replaced by a new one,
+// e.g. by logrotate, is reopened once the old one was read to the end.
+type followReader struct {
+	ctx      context.Context
+	path     string
+	interval time.Duration
+	file     *os.File
+	// offset is how far we read into file.
+	offset int64
+	// read is the input offset of what was returned, the offset it was opened at included,
+	// and lines the new lines returned. fileStart and fileLines are what they were when
+	// file was reopened, see rotation.
+	read      int64
+	lines     int
+	fileStart int64
+	fileLines int
+	rotated   bool
+	// partial is the last line read, still missing its new line.
+	partial []byte
+	// ready are complete lines not yet returned.
+	ready []byte
+	chunk []byte
+}
+
+// openFollow opens the file at path to be followed until ctx is done.
+func openFollow(ctx context.Context, path string) (*followReader, error) {
+	return openFollowAt(ctx, path, 0)
+}
+
+// openFollowAt opens the file at path to be followed from offset, e.g. to resume from a checkpoint.
+// A file shorter than offset was truncated in the meantime and is read from the start.
+func openFollowAt(ctx context.Context, path string, offset int64) (*followReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &followReader{
+		ctx:      ctx,
+		path:     path,
+		interval: followInterval,
+		file:     f,
+		offset:   offset,
+		read:     offset,
+		chunk:    make([]byte, 32*1024),
+	}, nil
+}
+
+// Read returns complete lines, waiting for them when needed. It returns io.EOF once ctx is done.
+func (r *followReader) Read(p []byte) (int, error) {
+	for len(r.ready) == 0 {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.ready)
+	r.ready = r.ready[n:]
+	r.read += int64(n)
+	r.lines += bytes.Count(p[:n], []byte{'\n'})
+	return n, nil
+}
+
+// rotation returns the input offset the current file starts at, as a jsonSource reading r
+// counts it, and how many new lines were returned before it. It's false when the file we
+// opened is still the one read.
+func (r *followReader) rotation() (int64, int, bool) {
+	return r.fileStart, r.fileLines, r.rotated
+}
+
+// fill reads what's new in the file, or waits for the file to change.
+func (r *followReader) fill() error {
+	n, err := r.file.Read(r.chunk)
+	if n > 0 {
+		r.offset += int64(n)
+		r.partial = append(r.partial, r.chunk[:n]...)
+		if i := bytes.LastIndexByte(r.partial, '\n'); i >= 0 {
+			r.ready = append(r.ready, r.partial[:i+1]...)
+			r.partial = append([]byte(nil), r.partial[i+1:]...)
+		}
+		return nil
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	// we are at the end of the file, it might have been rotated in the meantime.
+	reopened, err := r.reopen()
+	if err != nil || reopened {
+		return err
+	}
+
+	select {
+	case <-r.ctx.Done():
+		return io.EOF
+	case <-time.After(r.interval):
+		return nil
+	}
+}
+
+// reopen starts over when the file was truncated or replaced, it tells if it did.
+func (r *followReader) reopen() (bool, error) {
+	info, err := os.Stat(r.path)
+	if os.IsNotExist(err) {
+		// the new file isn't there yet.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	curr, err := r.file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if !os.SameFile(info, curr) {
+		f, err := os.Open(r.path)
+		if err != nil {
+			return false, err
+		}
+		r.file.Close()
+		r.file = f
+	} else if info.Size() < r.offset {
+		if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+	} else {
+		return false, nil
+	}
+
+	// a line left half written belongs to the old content.
+	r.offset = 0
+	r.partial = nil
+	r.fileStart, r.fileLines, r.rotated = r.read+int64(len(r.ready)), r.lines+bytes.Count(r.ready, []byte{'\n'}), true
+	return true, nil
+}
+
+// Close closes the followed file.
+func (r *followReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// appendFile appends s to the file at path.
+func appendFile(t *testing.T, path, s string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(s)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestFollowReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	appendFile(t, path, "line 1\nline")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := openFollow(ctx, path)
+	require.NoError(t, err)
+	defer r.Close()
+	r.interval = time.Millisecond
+
+	lines := make(chan string)
+	var leftover string
+	var lastErr error
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				leftover, lastErr = line, err
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	require.Equal(t, "line 1\n", <-lines)
+
+	// the line is only returned once it's complete.
+	appendFile(t, path, " 2\n")
+	require.Equal(t, "line 2\n", <-lines)
+
+	// truncated, e.g. copytruncate.
+	require.NoError(t, os.Truncate(path, 0))
+	appendFile(t, path, "line 3\n")
+	require.Equal(t, "line 3\n", <-lines)
+
+	// replaced by a new file, e.g. create, lines of the old file are read first.
+	appendFile(t, path, "line 4\n")
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendFile(t, path, "line 5\n")
+	require.Equal(t, "line 4\n", <-lines)
+	require.Equal(t, "line 5\n", <-lines)
+
+	appendFile(t, path, "half written")
+	cancel()
+	_, more := <-lines
+	require.False(t, more)
+	require.ErrorIs(t, lastErr, io.EOF)
+	require.Empty(t, leftover, "partial lines are never returned")
+}
+
+// syncBuffer is a bytes.Buffer safe to read while the command writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRootFollow(t *testing.T) {
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	var raw []json.RawMessage
+	require.NoError(t, json.Unmarshal(input, &raw))
+
+	path := filepath.Join(t.TempDir(), "events.log")
+	appendFile(t, path, string(raw[0])+"\n")
+
+	var stdout syncBuffer
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetArgs([]string{"--input_file=" + path, "--output=-", "--window_size=10", "--follow"})
+	defer func() {
+		rootCmd.SetOut(nil)
+		rootCmd.SetContext(context.Background())
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+		require.NoError(t, rootCmd.Flags().Set(FOLLOW_FLAG, "false"))
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- rootCmd.ExecuteContext(ctx)
+	}()
+
+	// rows show up as minutes close, before the input ends.
+	appendFile(t, path, string(raw[1])+"\n")
+	require.Eventually(t, func() bool {
+		return strings.Count(stdout.String(), "\n") == 5
+	}, 5*time.Second, 10*time.Millisecond)
+
+	appendFile(t, path, string(raw[2])+"\n")
+	require.Eventually(t, func() bool {
+		return strings.Count(stdout.String(), "\n") == 13
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// stopping writes the rows left.
+	cancel()
+	require.NoError(t, <-done)
+
+	want, err := os.ReadFile("./testResult.txt")
+	require.NoError(t, err)
+	require.Equal(t, string(want), stdout.String())
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var ErrUnknownOutputFormat = errors.New("unknown output format")
+var ErrInvalidDateFormat = errors.New("invalid date format")
+var ErrColumnClash = errors.New("output column named twice")
+
+// --output-format formats.
+const (
+	formatJSONArray = "json"
+	formatTable     = "table"
+	// ndjson, csv and tsv are named like the input formats.
+)
+
+// dateFormats are the named --date-format layouts, any other value is a go time layout.
+var dateFormats = map[string]string{
+	"default":     defaultDateLayout,
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"date":        "2006-01-02",
+}
+
+// outputOptions are the settings of the output format layer.
+type outputOptions struct {
+	// Format is one of the --output-format formats, ndjson by default.
+	Format string
+	// DateLayout overrides the layout of the date column, empty to keep the one of the step.
+	DateLayout string
+	// Columns are the csv, tsv and table columns, see outputColumns.
+	Columns []string
+	// Flush writes every row right away, e.g. with --follow.
+	Flush bool
+	// Resumed tells rows were already written, by a run resumed from a checkpoint,
+	// so the header or the array start aren't written again.
+	Resumed bool
+}
+
+// validateOutputFormat checks the --output-format and returns the layout of the --date-format.
+func validateOutputFormat(format, dateFormat string) (string, error) {
+	switch format {
+	case "", formatNDJSON, formatJSONArray, formatCSV, formatTSV, formatTable:
+	default:
+		return "", fmt.Errorf("%w %q, use one of: %s, %s, %s, %s, %s", ErrUnknownOutputFormat, format, formatNDJSON, formatJSONArray, formatCSV, formatTSV, formatTable)
+	}
+	if dateFormat == "" {
+		return "", nil
+	}
+	if layout, ok := dateFormats[dateFormat]; ok {
+		return layout, nil
+	}
+	// a layout without any element formats to itself.
+	if time.Date(2018, 12, 26, 18, 11, 0, 0, time.UTC).Format(dateFormat) == dateFormat {
+		return "", fmt.Errorf("%w %q, use a go time layout, e.g. 2006-01-02T15:04, or one of: default, date, rfc3339, rfc3339nano", ErrInvalidDateFormat, dateFormat)
+	}
+	return dateFormat, nil
+}
+
+// outputColumns returns the columns of csv, tsv and table output, in the order rows have them:
+// date, the --group-by labels in the given order, average_delivery_time, the stats in
+// statNames order, the quantiles in the given order, and correction with --late correct.
+// opts.Stats must be already parsed. Rows find their values by column name, in json too,
+// so a --group-by field named like another column, e.g. count, is an error.
+func outputColumns(opts smaOptions) ([]string, error) {
+	columns := append([]string{"date"}, opts.GroupBy...)
+	columns = append(columns, "average_delivery_time")
+	columns = append(columns, opts.Stats...)
+	for _, q := range opts.Quantiles {
+		columns = append(columns, quantileName(q))
+	}
+	if opts.Late == lateCorrect {
+		columns = append(columns, "correction")
+	}
+	seen := make(map[string]bool, len(columns))
+	for _, name := range columns {
+		if seen[name] {
+			return nil, fmt.Errorf("%w: %q, rename the --group-by field or leave it out", ErrColumnClash, name)
+		}
+		seen[name] = true
+	}
+	return columns, nil
+}
+
+// rowWriter is a sink writing rows to a file. Flush writes buffered rows, e.g. before
+// a checkpoint, and Close ends the output, e.g. the closing bracket of a json array.
+type rowWriter interface {
+	sink
+	Flush() error
+	Close() error
+}
+
+// newRowWriter creates the rowWriter of the opts format writing to w.
+func newRowWriter(w io.Writer, opts outputOptions) rowWriter {
+	switch opts.Format {
+	case formatJSONArray:
+		return &jsonArraySink{writerSink: writerSink{bw: bufio.NewWriter(w), flush: opts.Flush, layout: opts.DateLayout}, started: opts.Resumed}
+	case formatCSV, formatTSV, formatTable:
+		return newColumnSink(w, opts)
+	}
+	s := newWriterSink(w)
+	s.flush, s.layout = opts.Flush, opts.DateLayout
+	return s
+}
+
+// jsonArraySink writes rows as a json array, one row per line.
+type jsonArraySink struct {
+	writerSink
+	// started is set once the array start was written.
+	started bool
+}
+
+// Emit writes the row after the array start, or after a comma.
+func (s *jsonArraySink) Emit(row output) error {
+	sep := ",\n"
+	if !s.started {
+		sep, s.started = "[\n", true
+	}
+	if _, err := s.bw.WriteString(sep); err != nil {
+		return err
+	}
+	if err := s.writeRow(row); err != nil {
+		return err
+	}
+	if s.flush {
+		return s.bw.Flush()
+	}
+	return nil
+}
+
+// Close ends the array, an empty one when there were no rows.
+func (s *jsonArraySink) Close() error {
+	end := "\n]\n"
+	if !s.started {
+		end = "[]\n"
+	}
+	if _, err := s.bw.WriteString(end); err != nil {
+		return err
+	}
+	return s.bw.Flush()
+}
+
+// columnSink writes rows as csv, tsv or an aligned table, with a header row naming the columns.
+// Null rows of --gaps null have empty values, like missing ones.
+type columnSink struct {
+	bw      *bufio.Writer
+	csv     *csv.Writer
+	table   *tabwriter.Writer
+	opts    outputOptions
+	started bool
+	record  []string
+	// index maps a column name to its position.
+	index map[string]int
+}
+
+// newColumnSink creates a columnSink writing to w.
+func newColumnSink(w io.Writer, opts outputOptions) *columnSink {
+	s := &columnSink{
+		bw:      bufio.NewWriter(w),
+		opts:    opts,
+		started: opts.Resumed,
+		record:  make([]string, len(opts.Columns)),
+		index:   make(map[string]int, len(opts.Columns)),
+	}
+	for i, name := range opts.Columns {
+		s.index[name] = i
+	}
+	switch opts.Format {
+	case formatTable:
+		s.table = tabwriter.NewWriter(s.bw, 0, 0, 2, ' ', 0)
+	default:
+		s.csv = csv.NewWriter(s.bw)
+		if opts.Format == formatTSV {
+			s.csv.Comma = '\t'
+		}
+	}
+	return s
+}
+
+// Emit writes the row, after the header when it's the first one.
+func (s *columnSink) Emit(row output) error {
+	if err := s.header(); err != nil {
+		return err
+	}
+	if s.opts.DateLayout != "" {
+		row.layout = s.opts.DateLayout
+	}
+
+	for i := range s.record {
+		s.record[i] = ""
+	}
+	s.set("date", row.Date.Format(row.dateLayout()))
+	for _, l := range row.Labels {
+		s.set(l.Name, l.Value)
+	}
+	if !row.null {
+		if err := s.setNumber("average_delivery_time", row.AvgDeliveryTime); err != nil {
+			return err
+		}
+		for _, f := range row.Fields {
+			if err := s.setNumber(f.Name, f.Value); err != nil {
+				return err
+			}
+		}
+	}
+	if row.correction {
+		s.set("correction", "true")
+	}
+
+	if err := s.write(s.record); err != nil {
+		return err
+	}
+	if s.opts.Flush {
+		return s.Flush()
+	}
+	return nil
+}
+
+// header writes the column names once.
+func (s *columnSink) header() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	return s.write(s.opts.Columns)
+}
+
+func (s *columnSink) set(name, value string) {
+	if i, ok := s.index[name]; ok {
+		s.record[i] = value
+	}
+}
+
+// setNumber sets a number like json writes it, so every format has the same values.
+func (s *columnSink) setNumber(name string, value any) error {
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.set(name, string(bs))
+	return nil
+}
+
+func (s *columnSink) write(record []string) error {
+	if s.table != nil {
+		_, err := io.WriteString(s.table, strings.Join(record, "\t")+"\n")
+		return err
+	}
+	return s.csv.Write(record)
+}
+
+// Flush writes buffered rows, a table is aligned up to the rows written so far.
+func (s *columnSink) Flush() error {
+	if s.table != nil {
+		if err := s.table.Flush(); err != nil {
+			return err
+		}
+	} else {
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return s.bw.Flush()
+}
+
+// Close writes the header when there were no rows, and flushes.
+func (s *columnSink) Close() error {
+	if err := s.header(); err != nil {
+		return err
+	}
+	return s.Flush()
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowWriter(t *testing.T) {
+	date := time.Date(2018, 12, 26, 18, 24, 0, 0, time.UTC)
+	labels := []label{{Name: "client_name", Value: "air,liberty"}}
+	rows := []output{
+		{Date: date, Labels: labels, AvgDeliveryTime: 25.5, Fields: []field{{Name: "count", Value: int64(2)}, {Name: "p90", Value: float32(29.9)}}},
+		{Date: date.Add(time.Minute), Labels: labels, null: true, Fields: []field{{Name: "count", Value: int64(0)}, {Name: "p90", Value: float32(0)}}},
+	}
+	columns, err := outputColumns(smaOptions{GroupBy: []string{"client_name"}, Stats: []string{"count"}, Quantiles: []float64{0.9}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"date", "client_name", "average_delivery_time", "count", "p90"}, columns)
+
+	tcs := []struct {
+		name string
+		opts outputOptions
+		rows []output
+		want string
+	}{
+		{
+			name: "when format is ndjson should write json lines",
+			opts: outputOptions{},
+			rows: rows,
+			want: `{"date":"2018-12-26 18:24:00","client_name":"air,liberty","average_delivery_time":25.5,"count":2,"p90":29.9}
+{"date":"2018-12-26 18:25:00","client_name":"air,liberty","average_delivery_time":null,"count":null,"p90":null}
+`,
+		},
+		{
+			name: "when format is json should write an array",
+			opts: outputOptions{Format: formatJSONArray, DateLayout: time.RFC3339},
+			rows: rows[:1],
+			want: `[
+{"date":"2018-12-26T18:24:00Z","client_name":"air,liberty","average_delivery_time":25.5,"count":2,"p90":29.9}
+]
+`,
+		},
+		{
+			name: "when format is json and there are no rows should write an empty array",
+			opts: outputOptions{Format: formatJSONArray},
+			want: "[]\n",
+		},
+		{
+			name: "when format is csv should write a header and quote values",
+			opts: outputOptions{Format: formatCSV, Columns: columns},
+			rows: rows,
+			want: `date,client_name,average_delivery_time,count,p90
+2018-12-26 18:24:00,"air,liberty",25.5,2,29.9
+2018-12-26 18:25:00,"air,liberty",,,
+`,
+		},
+		{
+			name: "when format is tsv should change the date layout",
+			opts: outputOptions{Format: formatTSV, Columns: columns, DateLayout: "2006-01-02T15:04"},
+			rows: rows[:1],
+			want: "date\tclient_name\taverage_delivery_time\tcount\tp90\n2018-12-26T18:24\tair,liberty\t25.5\t2\t29.9\n",
+		},
+		{
+			name: "when format is csv and resumed should not write the header again",
+			opts: outputOptions{Format: formatCSV, Columns: columns, Resumed: true},
+			rows: rows[:1],
+			want: "2018-12-26 18:24:00,\"air,liberty\",25.5,2,29.9\n",
+		},
+		{
+			name: "when format is csv and there are no rows should write the header",
+			opts: outputOptions{Format: formatCSV, Columns: columns},
+			want: "date,client_name,average_delivery_time,count,p90\n",
+		},
+		{
+			name: "when format is table should align columns",
+			opts: outputOptions{Format: formatTable, Columns: columns},
+			rows: rows,
+			want: `date                 client_name  average_delivery_time  count  p90
+2018-12-26 18:24:00  air,liberty  25.5                   2      29.9
+2018-12-26 18:25:00  air,liberty                                
+`,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := newRowWriter(&buf, tc.opts)
+			for _, row := range tc.rows {
+				require.NoError(t, w.Emit(row))
+			}
+			require.NoError(t, w.Close())
+			require.Equal(t, tc.want, buf.String())
+		})
+	}
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	layout, err := validateOutputFormat(formatCSV, "rfc3339")
+	require.NoError(t, err)
+	require.Equal(t, time.RFC3339, layout)
+
+	layout, err = validateOutputFormat("", "02/01/2006 15:04")
+	require.NoError(t, err)
+	require.Equal(t, "02/01/2006 15:04", layout)
+
+	_, err = validateOutputFormat("xlsx", "")
+	require.ErrorIs(t, err, ErrUnknownOutputFormat)
+	_, err = validateOutputFormat(formatCSV, "yyyy-mm-dd")
+	require.ErrorIs(t, err, ErrInvalidDateFormat)
+}
+
+func TestOutputColumnsClash(t *testing.T) {
+	tcs := []struct {
+		name string
+		opts smaOptions
+	}{
+		{
+			name: "when a group-by field is named like a stat should error",
+			opts: smaOptions{GroupBy: []string{"client_name", "count"}, Stats: []string{"count"}},
+		},
+		{
+			name: "when a group-by field is named like a quantile should error",
+			opts: smaOptions{GroupBy: []string{"p90"}, Quantiles: []float64{0.9}},
+		},
+		{
+			name: "when a group-by field is named like the date should error",
+			opts: smaOptions{GroupBy: []string{"date"}},
+		},
+		{
+			name: "when a group-by field is given twice should error",
+			opts: smaOptions{GroupBy: []string{"client_name", "client_name"}},
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := outputColumns(tc.opts)
+			require.ErrorIs(t, err, ErrColumnClash)
+		})
+	}
+
+	// streams of any output format check it too.
+	_, err := newStreamerFor(engines["fifo"], smaOptions{Window: 10 * time.Minute, GroupBy: []string{"sum"}, Stats: []string{"sum"}}, funcSink(func(output) error { return nil }))
+	require.ErrorIs(t, err, ErrColumnClash)
+}
+
+func TestRootOutputFormat(t *testing.T) {
+	defer func() {
+		rootCmd.SetOut(nil)
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FORMAT_FLAG, formatNDJSON))
+		require.NoError(t, rootCmd.Flags().Set(DATE_FORMAT_FLAG, ""))
+	}()
+
+	var stdout bytes.Buffer
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetArgs([]string{"--input_file=./testInput.json", "--output=-", "--window_size=10", "--output-format=csv", "--date-format=2006-01-02T15:04"})
+	require.NoError(t, rootCmd.Execute())
+
+	lines := strings.Split(stdout.String(), "\n")
+	require.Equal(t, "date,average_delivery_time", lines[0])
+	require.Equal(t, "2018-12-26T18:11,0", lines[1])
+	require.Equal(t, "2018-12-26T18:24,42.5", lines[len(lines)-2])
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownGaps = errors.New("unknown gaps mode")
+
+// --gaps modes, they tell what to write for buckets whose window has no events.
+const (
+	// gapsZero writes a row with zero values, the original behavior.
+	gapsZero = "zero"
+	// gapsCarry writes a row carrying the values of the last non empty window.
+	gapsCarry = "carry"
+	// gapsSkip writes nothing.
+	gapsSkip = "skip"
+	// gapsNull writes a single row with null values where the gap starts.
+	gapsNull = "null"
+)
+
+// validateGaps checks the given --gaps mode.
+func validateGaps(mode string) error {
+	switch mode {
+	case "", gapsZero, gapsCarry, gapsSkip, gapsNull:
+		return nil
+	}
+	return fmt.Errorf("%w %q, use one of: %s, %s, %s, %s", ErrUnknownGaps, mode, gapsZero, gapsCarry, gapsSkip, gapsNull)
+}
+
+// gapsJump tells if, once every window is drained, the engine can jump straight to
+// the bucket of the next event instead of stepping through empty buckets.
+// This makes run time depend on the events and not on the time they span.
+func gapsJump(mode string) bool {
+	return mode == gapsSkip || mode == gapsNull
+}
+
+// gapRow applies the --gaps mode to the row of a group, it returns false when
+// the row must not be written.
+func (g *group) gapRow(row output) (output, bool) {
+	if !g.empty() {
+		g.inGap = false
+		g.lastRow = row
+		return row, true
+	}
+
+	switch g.opts.Gaps {
+	case gapsSkip:
+		return row, false
+	case gapsNull:
+		// only the first bucket of the gap, following ones would all be null too.
+		if g.inGap {
+			return row, false
+		}
+		g.inGap = true
+		row.null = true
+		return row, true
+	case gapsCarry:
+		// nothing to carry before the first non empty window.
+		if g.lastRow.Date.IsZero() {
+			return row, true
+		}
+		carried := g.lastRow
+		carried.Date = row.Date
+		return carried, true
+	}
+	return row, true
+}
+
+// empty tells if the group window has no events.
+func (g *group) empty() bool {
+	return g.queue.Aggregates().count == 0
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamSMAGaps(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+
+	tcs := []struct {
+		gaps     string
+		wantRows int
+		wantNull int
+	}{
+		// 3 minutes window leaves empty windows at 18:11, 18:15 and 18:19-18:23.
+		{gaps: gapsZero, wantRows: 14},
+		{gaps: gapsCarry, wantRows: 14},
+		{gaps: gapsSkip, wantRows: 7},
+		{gaps: gapsNull, wantRows: 10, wantNull: 3},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run("when gaps is "+tc.gaps, func(t *testing.T) {
+			got, err := collectSMA(&sliceSource{events: events}, engines["fifo"], smaOptions{
+				Window: 3 * time.Minute,
+				Gaps:   tc.gaps,
+			})
+			require.NoError(t, err)
+			require.Len(t, got, tc.wantRows)
+
+			nulls := 0
+			for _, row := range got {
+				if row.null {
+					nulls++
+				}
+			}
+			require.Equal(t, tc.wantNull, nulls)
+		})
+	}
+
+	_, err = collectSMA(&sliceSource{events: events}, engines["fifo"], smaOptions{Window: 3 * time.Minute, Gaps: "unknown"})
+	require.ErrorIs(t, err, ErrUnknownGaps)
+}
+
+func TestStreamSMAGapsJump(t *testing.T) {
+	first, err := parseTime("2018-12-26 18:11:08.509654")
+	require.NoError(t, err)
+	// a hundred years apart would be ~52 million minutes to step through.
+	events := []event{
+		{Timestamp: customTime{first}, Duration: 20},
+		{Timestamp: customTime{first.AddDate(100, 0, 0)}, Duration: 40},
+	}
+
+	for _, gaps := range []string{gapsSkip, gapsNull} {
+		got, err := collectSMA(&sliceSource{events: events}, engines["fifo"], smaOptions{
+			Window: 10 * time.Minute,
+			Metric: "ema",
+			Gaps:   gaps,
+		})
+		require.NoError(t, err)
+		// rows while each event is in the window, plus null rows where gaps start.
+		require.LessOrEqual(t, len(got), 24)
+
+		last := resultKey{Date: bucket(events[1].Timestamp.Time, time.Minute).Add(time.Minute)}
+		// the first event decayed for a century, the ema is all about the last one.
+		require.InDelta(t, 40, got[last].AvgDeliveryTime, 0.0001)
+	}
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// label is an event field and its value identifying a group, e.g. client_name=airliberty.
+type label struct {
+	Name  string
+	Value string
+}
+
+// group keeps a separate window state for events sharing the same --group-by values.
+type group struct {
+	key    string
+	labels []label
+	// start is the bucket of the first event in the group, rows are emitted from there.
+	start  time.Time
+	queue  windowQueue
+	metric metric
+	// quantiles is nil when no --quantiles were asked.
+	quantiles quantileWindow
+	// stats is nil when no --stats were asked.
+	stats *windowStats
+	opts  smaOptions
+	// lastRow and inGap keep track of empty windows for --gaps.
+	lastRow output
+	inGap   bool
+	// history keeps enqueued events for two windows with --late correct,
+	// rows of late events are recalculated from it.
+	history []event
+}
+
+// enqueue adds the event to the group window.
+func (g *group) enqueue(e event) {
+	if g.opts.Late == lateCorrect {
+		g.history = append(g.history, e)
+	}
+	g.queue.Enqueue(e)
+	g.metric.add(e)
+	if g.quantiles != nil {
+		g.quantiles.add(float64(e.Duration))
+	}
+	if g.stats != nil {
+		g.stats.add(e)
+	}
+}
+
+// evict removes the events that left the window from quantiles and stats as well.
+func (g *group) evict(e event) {
+	if g.quantiles != nil {
+		g.quantiles.remove(float64(e.Duration))
+	}
+	if g.stats != nil {
+		g.stats.remove(e)
+	}
+}
+
+// trimHistory drops history events no late event can need anymore once currBucket is finalized,
+// the ones the oldest correctable row(one window back) could have in its window.
+func (g *group) trimHistory(currBucket time.Time) {
+	oldest := currBucket.Add(-2 * g.opts.Window)
+	i := 0
+	for i < len(g.history) && !g.history[i].Timestamp.After(oldest) {
+		i++
+	}
+	g.history = g.history[i:]
+}
+
+// row evicts events out of the window and returns the group output for the given bucket.
+func (g *group) row(currBucket time.Time) output {
+	var onEvict func(e event)
+	if g.quantiles != nil || g.stats != nil {
+		onEvict = g.evict
+	}
+	g.queue.Evict(currBucket, g.opts.Window, onEvict)
+
+	row := output{
+		Date:            currBucket,
+		layout:          dateLayout(g.opts.Step),
+		Labels:          g.labels,
+		AvgDeliveryTime: g.metric.value(currBucket, g.queue),
+	}
+	if g.stats != nil {
+		row.Fields = append(row.Fields, g.stats.fields(g.queue)...)
+	}
+	if g.quantiles != nil {
+		for _, q := range g.opts.Quantiles {
+			row.Fields = append(row.Fields, field{Name: quantileName(q), Value: float32(g.quantiles.quantile(q))})
+		}
+	}
+	return row
+}
+
+// groupSet routes events to their group, creating groups as new keys show up.
+// With no fields all events fall into the same group, which is the global sma.
+type groupSet struct {
+	opts         smaOptions
+	newQueue     func() windowQueue
+	newMetric    func() metric
+	newQuantiles func() quantileWindow
+	byKey        map[string]*group
+	// sorted keeps groups ordered by key so rows of the same minute have a stable order.
+	sorted []*group
+}
+
+// newGroupSet creates a groupSet grouping by the opts.GroupBy event fields,
+// newQuantiles is nil when no quantiles were asked.
+func newGroupSet(opts smaOptions, newQueue func() windowQueue, newMetric func() metric, newQuantiles func() quantileWindow) *groupSet {
+	return &groupSet{
+		opts:         opts,
+		newQueue:     newQueue,
+		newMetric:    newMetric,
+		newQuantiles: newQuantiles,
+		byKey:        make(map[string]*group),
+	}
+}
+
+// newGroup creates a group with a fresh window state, it isn't added to the set.
+func (s *groupSet) newGroup(key string, labels []label, start time.Time) *group {
+	g := &group{
+		key:    key,
+		labels: labels,
+		start:  start,
+		queue:  s.newQueue(),
+		metric: s.newMetric(),
+		opts:   s.opts,
+	}
+	if s.newQuantiles != nil {
+		g.quantiles = s.newQuantiles()
+	}
+	if len(s.opts.Stats) > 0 {
+		g.stats = newWindowStats(s.opts.Stats)
+	}
+	return g
+}
+
+// get returns the group of the given event.
+func (s *groupSet) get(e event) *group {
+	values := make([]string, len(s.opts.GroupBy))
+	for i, name := range s.opts.GroupBy {
+		values[i], _ = e.field(name)
+	}
+	// unit separator won't show up in any sane field value.
+	key := strings.Join(values, "\x1f")
+
+	if g, ok := s.byKey[key]; ok {
+		return g
+	}
+
+	var labels []label
+	for i, name := range s.opts.GroupBy {
+		labels = append(labels, label{Name: name, Value: values[i]})
+	}
+	g := s.newGroup(key, labels, bucket(e.Timestamp.Time, s.opts.Step))
+	s.byKey[key] = g
+
+	i := sort.Search(len(s.sorted), func(i int) bool { return s.sorted[i].key >= key })
+	s.sorted = append(s.sorted, nil)
+	copy(s.sorted[i+1:], s.sorted[i:])
+	s.sorted[i] = g
+	return g
+}
+
+// dropDrained removes the groups whose window is empty after the row of currBucket,
+// and returns them. Groups with no row yet, history for --late correct, or an ema,
+// which carries on past the window, are kept, and so is next, about to get an event.
+func (s *groupSet) dropDrained(currBucket time.Time, next *group) []*group {
+	var dropped []*group
+	kept := s.sorted[:0]
+	for _, g := range s.sorted {
+		_, ema := g.metric.(*emaMetric)
+		if g != next && !currBucket.Before(g.start) && g.empty() && len(g.history) == 0 && !ema {
+			delete(s.byKey, g.key)
+			dropped = append(dropped, g)
+			continue
+		}
+		kept = append(kept, g)
+	}
+	// let go of the dropped groups left past the end.
+	for i := len(kept); i < len(s.sorted); i++ {
+		s.sorted[i] = nil
+	}
+	s.sorted = kept
+	return dropped
+}
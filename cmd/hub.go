@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// hub is a sink handing rows to subscribers, e.g. clients of GET /stream.
+// Emit never waits for a subscriber: rows that don't fit in its buffer are
+// dropped and counted, so a slow client can't hold back ingestion.
+type hub struct {
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	buffer int
+	closed bool
+}
+
+// subscriber receives the rows matching its filter in rows, which is closed
+// when it unsubscribes or the hub is closed.
+type subscriber struct {
+	rows chan output
+	// filter maps label names to the values wanted, rows must match all names.
+	filter map[string][]string
+	// dropped counts rows left out since they were last reported.
+	dropped atomic.Int64
+}
+
+// newHub creates a hub buffering up to buffer rows per subscriber.
+func newHub(buffer int) *hub {
+	return &hub{subs: make(map[*subscriber]struct{}), buffer: buffer}
+}
+
+// subscribe adds a subscriber for rows matching filter, nil matching all rows.
+func (h *hub) subscribe(filter map[string][]string) *subscriber {
+	sub := &subscriber{rows: make(chan output, h.buffer), filter: filter}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(sub.rows)
+		return sub
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe removes the subscriber and closes its rows.
+func (h *hub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// whoever removes it from subs closes it, it might have been closed by close already.
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.rows)
+	}
+}
+
+// Emit hands the row to every matching subscriber with room for it.
+func (h *hub) Emit(row output) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if !sub.match(row) {
+			continue
+		}
+		select {
+		case sub.rows <- row:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+	return nil
+}
+
+// close closes every subscriber, later ones are closed right away.
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.rows)
+	}
+	h.closed = true
+}
+
+// len returns how many subscribers there are.
+func (h *hub) len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// match tells if the row labels have one of the wanted values for every filtered name.
+func (s *subscriber) match(row output) bool {
+	for name, values := range s.filter {
+		found := false
+		for _, l := range row.Labels {
+			if l.Name != name {
+				continue
+			}
+			for _, v := range values {
+				found = found || l.Value == v
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub(t *testing.T) {
+	date := time.Date(2018, 12, 26, 18, 12, 0, 0, time.UTC)
+	airliberty := output{Date: date, Labels: []label{{Name: "client_name", Value: "airliberty"}}, AvgDeliveryTime: 20}
+	taxiEats := output{Date: date, Labels: []label{{Name: "client_name", Value: "taxi-eats"}}, AvgDeliveryTime: 54}
+
+	h := newHub(1)
+	all := h.subscribe(nil)
+	filtered := h.subscribe(map[string][]string{"client_name": {"taxi-eats", "other"}})
+	none := h.subscribe(map[string][]string{"source_language": {"en"}})
+
+	// all has room for one row only, emitting must not wait for it.
+	require.NoError(t, h.Emit(airliberty))
+	require.NoError(t, h.Emit(taxiEats))
+
+	require.Equal(t, airliberty, <-all.rows)
+	require.Equal(t, int64(1), all.dropped.Load(), "rows that don't fit are dropped")
+	require.Equal(t, taxiEats, <-filtered.rows)
+	require.Equal(t, int64(0), filtered.dropped.Load())
+	require.Len(t, none.rows, 0, "rows without the label don't match")
+
+	h.unsubscribe(filtered)
+	_, ok := <-filtered.rows
+	require.False(t, ok, "unsubscribing closes rows")
+
+	h.close()
+	h.unsubscribe(all)
+	_, ok = <-all.rows
+	require.False(t, ok, "closing the hub closes rows")
+	_, ok = <-h.subscribe(nil).rows
+	require.False(t, ok, "subscribing to a closed hub gets closed rows")
+}
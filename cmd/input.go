@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrUnknownInputFormat = errors.New("unknown input format")
+var ErrInvalidDelimiter = errors.New("delimiter must be a single character")
+var ErrInvalidColumns = errors.New("invalid columns")
+
+// --input-format formats, json and ndjson are both read by jsonSource.
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+	formatTSV    = "tsv"
+)
+
+// inputFormats maps file extensions to their input format.
+var inputFormats = map[string]string{
+	".json":   formatJSON,
+	".ndjson": formatNDJSON,
+	".jsonl":  formatNDJSON,
+	".log":    formatNDJSON,
+	".csv":    formatCSV,
+	".tsv":    formatTSV,
+	".tab":    formatTSV,
+}
+
+// inputOptions are the settings of the input format layer.
+type inputOptions struct {
+	// Format is one of the --input-format formats, empty to detect it.
+	Format string
+	// Delimiter separates csv columns, ',' for csv and a tab for tsv by default.
+	Delimiter string
+	// Columns maps event fields to the csv columns holding them, e.g. duration=time_ms.
+	Columns map[string]string
+}
+
+// validateInputFormat checks the --input-format and --delimiter flags.
+func validateInputFormat(opts inputOptions) error {
+	switch opts.Format {
+	case "", formatJSON, formatNDJSON, formatCSV, formatTSV:
+	default:
+		return fmt.Errorf("%w %q, use one of: %s, %s, %s, %s", ErrUnknownInputFormat, opts.Format, formatJSON, formatNDJSON, formatCSV, formatTSV)
+	}
+	_, err := delimiter(opts)
+	return err
+}
+
+// detectInputFormat tells the format of the input from the file extension, see extInputFormat,
+// or else from its first bytes: json starts with '{' or '[', and a header with tabs is tsv.
+// Bytes are only peeked, r still reads them.
+func detectInputFormat(filename string, r *bufio.Reader) (string, error) {
+	if format, ok := extInputFormat(filename); ok {
+		return format, nil
+	}
+
+	// bytes are peeked one at a time, a followed file or stdin might not have more yet.
+	n := 0
+blanks:
+	for {
+		b, err := peekByte(r, n)
+		if err != nil {
+			// blank or too long to tell, json is what we always read.
+			return formatJSON, nil
+		}
+		n++
+		switch b {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '{':
+			return formatNDJSON, nil
+		case '[':
+			return formatJSON, nil
+		}
+		break blanks
+	}
+
+	// a header with tabs is tsv.
+	for ; ; n++ {
+		b, err := peekByte(r, n)
+		if err != nil || b == '\n' {
+			return formatCSV, nil
+		}
+		if b == '\t' {
+			return formatTSV, nil
+		}
+	}
+}
+
+// extInputFormat tells the format of the input from the file extension, the one before
+// the compression one for compressed files.
+func extInputFormat(filename string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if compressionExts[ext] {
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(filename, filepath.Ext(filename))))
+	}
+	format, ok := inputFormats[ext]
+	return format, ok
+}
+
+// peekByte returns byte i of r without reading it.
+func peekByte(r *bufio.Reader, i int) (byte, error) {
+	peeked, err := r.Peek(i + 1)
+	if err != nil {
+		return 0, err
+	}
+	return peeked[i], nil
+}
+
+// isJSONFormat tells if events of the format are read by jsonSource.
+func isJSONFormat(format string) bool {
+	return format == formatJSON || format == formatNDJSON
+}
+
+// delimiter returns the csv delimiter of opts.
+func delimiter(opts inputOptions) (rune, error) {
+	d := opts.Delimiter
+	if d == `\t` || d == "tab" {
+		d = "\t"
+	}
+	if d == "" {
+		if opts.Format == formatTSV {
+			return '\t', nil
+		}
+		return ',', nil
+	}
+	r, size := utf8.DecodeRuneInString(d)
+	if size != len(d) || r == utf8.RuneError || r == '"' || r == '\n' || r == '\r' {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidDelimiter, opts.Delimiter)
+	}
+	return r, nil
+}
+
+// csvSource decodes events from csv, or tsv, with a header row naming the event fields.
+// Columns can be mapped to event fields with other names, columns that aren't
+// event fields go into Extra like unknown json fields do.
+type csvSource struct {
+	r       *csv.Reader
+	columns map[string]string
+	// fields are the event field of each column, read from the header.
+	fields []string
+}
+
+// newCSVSource creates a csvSource reading from r, the delimiter and columns come from opts.
+func newCSVSource(r io.Reader, opts inputOptions) (*csvSource, error) {
+	comma, err := delimiter(opts)
+	if err != nil {
+		return nil, err
+	}
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	// tsv exports don't quote fields, a quote is just part of the value.
+	cr.LazyQuotes = comma == '\t'
+	cr.ReuseRecord = true
+	return &csvSource{r: cr, columns: opts.Columns}, nil
+}
+
+// Next decodes the next row into an event.
+func (s *csvSource) Next() (event, error) {
+	if s.fields == nil {
+		if err := s.header(); err != nil {
+			return event{}, err
+		}
+	}
+
+	offset := s.r.InputOffset()
+	record, err := s.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		// the reader carries on from the next row.
+		return event{}, newEventError(err, parseErr.StartLine, offset, nil)
+	}
+	if err != nil {
+		return event{}, err
+	}
+	line, _ := s.r.FieldPos(0)
+	e, err := s.event(record)
+	if err != nil {
+		return event{}, newEventError(err, line, offset, s.raw(record))
+	}
+	return e, nil
+}
+
+// raw writes record back as a row, for errors.
+func (s *csvSource) raw(record []string) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = s.r.Comma
+	w.Write(record)
+	w.Flush()
+	return bytes.TrimRight(buf.Bytes(), "\r\n")
+}
+
+// header reads the header row and maps its columns to event fields.
+func (s *csvSource) header() error {
+	record, err := s.r.Read()
+	if err != nil {
+		return err
+	}
+
+	// columns maps fields to columns, here we need it the other way around.
+	byColumn := make(map[string]string, len(s.columns))
+	for field, column := range s.columns {
+		byColumn[column] = field
+	}
+	found := make(map[string]bool, len(record))
+	s.fields = make([]string, len(record))
+	for i, column := range record {
+		column = strings.TrimSpace(column)
+		if i == 0 {
+			// excel likes to start files with a byte order mark.
+			column = strings.TrimPrefix(column, "\ufeff")
+		}
+		found[column] = true
+		s.fields[i] = column
+		if field, ok := byColumn[column]; ok {
+			s.fields[i] = field
+		}
+	}
+
+	for field, column := range s.columns {
+		if !found[column] {
+			return fmt.Errorf("%w: column %q of %s isn't in the header", ErrInvalidColumns, column, field)
+		}
+	}
+	if !containsString(s.fields, "timestamp") {
+		return fmt.Errorf("%w: no timestamp column in the header, map one with timestamp=<column>", ErrInvalidColumns)
+	}
+	return nil
+}
+
+// event turns a row into an event, it's validated like json events are.
+func (s *csvSource) event(record []string) (event, error) {
+	var e event
+	for i, value := range record {
+		var err error
+		switch field := s.fields[i]; field {
+		case "timestamp":
+			if value != "" {
+				e.Timestamp.Time, err = parseTime(value)
+			}
+		case "translation_id":
+			e.TranslationID = value
+		case "source_language":
+			e.SourceLanguage = value
+		case "target_language":
+			e.TargetLanguage = value
+		case "client_name":
+			e.ClientName = value
+		case "event_name":
+			e.EventName = value
+		case "nr_words":
+			e.NrWords, err = atoi(value)
+		case "duration":
+			e.Duration, err = atoi(value)
+		default:
+			if e.Extra == nil {
+				e.Extra = make(map[string]json.RawMessage)
+			}
+			// csv values are strings, numeric filters still parse them.
+			e.Extra[field], err = json.Marshal(value)
+		}
+		if err != nil {
+			return event{}, &fieldError{field: s.fields[i], err: fmt.Errorf("%w: %v", ErrInvalidEvent, err)}
+		}
+	}
+	return e, e.validate()
+}
+
+// atoi parses an integer column, empty ones are 0 like missing json fields.
+func atoi(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(strings.TrimSpace(s))
+}
+
+// newEventSource creates the eventSource of the given format reading from r.
+func newEventSource(r io.Reader, opts inputOptions, format string) (eventSource, error) {
+	if isJSONFormat(format) {
+		return newJSONSource(r), nil
+	}
+	return newCSVSource(r, opts)
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testCSV = `timestamp,translation_id,source_language,target_language,client_name,event_name,duration,nr_words
+2018-12-26 18:11:08.509654,5aa5b2f39f7254a75aa5,en,fr,airliberty,translation_delivered,20,30
+2018-12-26 18:15:19.903159,5aa5b2f39f7254a75aa4,en,fr,airliberty,translation_delivered,31,30
+2018-12-26 18:23:19.903159,5aa5b2f39f7254a75bb3,en,fr,taxi-eats,translation_delivered,54,100
+`
+
+func TestDetectInputFormat(t *testing.T) {
+	tcs := []struct {
+		name     string
+		filename string
+		input    string
+		want     string
+	}{
+		{name: "when extension is csv should be csv", filename: "events.CSV", input: "[]", want: formatCSV},
+		{name: "when extension is jsonl should be ndjson", filename: "events.jsonl", want: formatNDJSON},
+		{name: "when compressed should use the extension before", filename: "events.tsv.gz", want: formatTSV},
+		{name: "when content is an array should be json", filename: "-", input: "\n  [{}]", want: formatJSON},
+		{name: "when content is an object should be ndjson", filename: "events", input: `{"duration":1}`, want: formatNDJSON},
+		{name: "when header has commas should be csv", filename: "-", input: testCSV, want: formatCSV},
+		{name: "when header has tabs should be tsv", filename: "-", input: "timestamp\tduration\n", want: formatTSV},
+		{name: "when content is empty should be json", filename: "-", input: "  ", want: formatJSON},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tc.input))
+			got, err := detectInputFormat(tc.filename, r)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+
+			rest, _ := r.ReadString(0)
+			require.Equal(t, tc.input, rest, "detecting reads nothing")
+		})
+	}
+}
+
+func TestCSVSource(t *testing.T) {
+	jsonEvents, err := readAll(newJSONSource(strings.NewReader(mustReadFile(t, "./testInput.json"))))
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name    string
+		input   string
+		opts    inputOptions
+		want    []event
+		wantErr error
+	}{
+		{
+			name:  "when input is csv should decode the same events as json",
+			input: testCSV,
+			opts:  inputOptions{Format: formatCSV},
+			want:  jsonEvents,
+		},
+		{
+			name:  "when input is tsv should decode the same events as json",
+			input: strings.ReplaceAll(testCSV, ",", "\t"),
+			opts:  inputOptions{Format: formatTSV},
+			want:  jsonEvents,
+		},
+		{
+			name:  "when columns are mapped should decode them as event fields",
+			input: "created_at;time_ms;team\n2018-12-26 18:11:08.509654;20;core\n",
+			opts:  inputOptions{Format: formatCSV, Delimiter: ";", Columns: map[string]string{"timestamp": "created_at", "duration": "time_ms"}},
+			want: []event{{
+				Timestamp: jsonEvents[0].Timestamp,
+				Duration:  20,
+				Extra:     map[string]json.RawMessage{"team": json.RawMessage(`"core"`)},
+			}},
+		},
+		{
+			name:    "when a mapped column is missing should error",
+			input:   testCSV,
+			opts:    inputOptions{Columns: map[string]string{"duration": "time_ms"}},
+			wantErr: ErrInvalidColumns,
+		},
+		{
+			name:    "when there's no timestamp column should error",
+			input:   "duration\n20\n",
+			wantErr: ErrInvalidColumns,
+		},
+		{
+			name:    "when duration isn't a number should error",
+			input:   "timestamp,duration\n2018-12-26 18:11:08.509654,fast\n",
+			wantErr: ErrInvalidEvent,
+		},
+		{
+			name:    "when timestamp is missing should error like json",
+			input:   "timestamp,duration\n,20\n",
+			wantErr: ErrInvalidEvent,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			src, err := newCSVSource(strings.NewReader(tc.input), tc.opts)
+			require.NoError(t, err)
+			got, err := readAll(src)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, got, len(tc.want))
+			for i := range tc.want {
+				require.True(t, tc.want[i].Timestamp.Equal(got[i].Timestamp.Time))
+				got[i].Timestamp = tc.want[i].Timestamp
+				require.Equal(t, tc.want[i], got[i])
+			}
+		})
+	}
+
+	_, err = newCSVSource(strings.NewReader(testCSV), inputOptions{Delimiter: ";;"})
+	require.ErrorIs(t, err, ErrInvalidDelimiter)
+}
+
+func TestRootCSV(t *testing.T) {
+	want, err := os.ReadFile("./testResult.txt")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "events.csv")
+	require.NoError(t, os.WriteFile(csvPath, []byte(testCSV), 0o644))
+	semicolons := filepath.Join(dir, "events.txt")
+	require.NoError(t, os.WriteFile(semicolons, []byte(strings.ReplaceAll(testCSV, ",", ";")), 0o644))
+
+	defer func() {
+		rootCmd.SetIn(nil)
+		rootCmd.SetOut(nil)
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FORMAT_FLAG, ""))
+		require.NoError(t, rootCmd.Flags().Set(DELIMITER_FLAG, ""))
+	}()
+
+	tcs := []struct {
+		name    string
+		args    []string
+		stdin   string
+		wantErr error
+	}{
+		{name: "when extension is csv should read csv", args: []string{"--input_file=" + csvPath}},
+		{name: "when stdin is csv should detect it", args: []string{"--input_file=-"}, stdin: testCSV},
+		{name: "when delimiter is given should read it", args: []string{"--input_file=" + semicolons, "--input-format=csv", "--delimiter=;"}},
+		{name: "when format is unknown should error", args: []string{"--input_file=" + csvPath, "--input-format=xml"}, wantErr: ErrUnknownInputFormat},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			rootCmd.SetIn(strings.NewReader(tc.stdin))
+			rootCmd.SetOut(&stdout)
+			rootCmd.SetArgs(append([]string{"--output=-", "--window_size=10", "--input-format=", "--delimiter="}, tc.args...))
+			err := rootCmd.Execute()
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, string(want), stdout.String())
+		})
+	}
+}
+
+// mustReadFile returns the content of the file at path.
+func mustReadFile(t *testing.T, path string) string {
+	bs, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(bs)
+}
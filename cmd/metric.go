@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrUnknownMetric = errors.New("unknown metric")
+var ErrInvalidAlpha = errors.New("alpha must be in (0, 1]")
+
+// metric turns the window of a group into the value of its output row.
+// All metrics share the bucket-stepping loop of StreamSMA and only differ
+// in how events of the window are weighted.
+type metric interface {
+	// add is called for every event enqueued in the group.
+	add(e event)
+	// value is called once per bucket, after the queue evicted old events.
+	value(currBucket time.Time, queue windowQueue) float32
+}
+
+// metrics is the registry of moving averages selectable with --metric.
+var metrics = map[string]func(opts smaOptions) metric{
+	"sma": func(opts smaOptions) metric { return smaMetric{} },
+	"wma": func(opts smaOptions) metric { return wmaMetric{buckets: opts.windowBuckets(), step: opts.Step} },
+	"ema": func(opts smaOptions) metric { return &emaMetric{alpha: opts.emaAlpha(), step: opts.Step} },
+}
+
+// metricFactory returns the constructor of the metric with the given name,
+// each group gets its own metric since some of them keep state.
+func metricFactory(opts smaOptions) (func() metric, error) {
+	name := opts.Metric
+	if name == "" {
+		name = "sma"
+	}
+	newMetric, ok := metrics[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, use one of: %s", ErrUnknownMetric, name, metricNames())
+	}
+	if opts.Alpha < 0 || opts.Alpha > 1 {
+		return nil, ErrInvalidAlpha
+	}
+	return func() metric { return newMetric(opts) }, nil
+}
+
+// metricNames lists registered metrics in alphabetical order.
+func metricNames() string {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// windowBuckets is how many steps fit in the window, e.g. 10 for a 10m window and 1m step.
+func (o smaOptions) windowBuckets() int64 {
+	n := int64(o.Window / o.Step)
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// emaAlpha returns the smoothing factor of the ema.
+// It comes from --alpha, or from --half-life, and defaults to the usual 2/(N+1)
+// where N is the window in steps, so ema and sma have about the same lag.
+func (o smaOptions) emaAlpha() float64 {
+	switch {
+	case o.Alpha > 0:
+		return o.Alpha
+	case o.HalfLife > 0:
+		// after half-life the weight of an event is halved.
+		return 1 - math.Pow(2, -float64(o.Step)/float64(o.HalfLife))
+	}
+	return 2 / (float64(o.windowBuckets()) + 1)
+}
+
+// smaMetric is the simple moving average, every event in the window weights the same.
+type smaMetric struct{}
+
+func (smaMetric) add(e event) {}
+
+func (smaMetric) value(currBucket time.Time, queue windowQueue) float32 {
+	return queue.Avg()
+}
+
+// wmaMetric is the linearly weighted moving average over buckets:
+// events of the most recent bucket weight the number of buckets in the window,
+// the ones of the bucket before one less, and so on until the oldest bucket which weights 1.
+type wmaMetric struct {
+	buckets int64
+	step    time.Duration
+}
+
+func (wmaMetric) add(e event) {}
+
+func (m wmaMetric) value(currBucket time.Time, queue windowQueue) float32 {
+	var sum, weights float64
+	queue.Each(func(e event) {
+		// age is how many buckets before the most recent bucket the event is.
+		age := int64(currBucket.Sub(bucket(e.Timestamp.Time, m.step).Add(m.step)) / m.step)
+		w := float64(m.buckets - age)
+		if w < 1 {
+			w = 1
+		}
+		sum += w * float64(e.Duration)
+		weights += w
+	})
+	if weights > 0 {
+		return float32(sum / weights)
+	}
+	return 0
+}
+
+// emaMetric is the exponential moving average over buckets.
+// Each step the smoothed sum and count of durations are updated with the
+// events of the last bucket: s = alpha*bucket + (1-alpha)*s, and ema = sum/count.
+// Buckets without events decay sum and count the same, so the ema carries on.
+type emaMetric struct {
+	alpha       float64
+	step        time.Duration
+	bucketSum   float64
+	bucketCount float64
+	sum         float64
+	count       float64
+	// last is the previous bucket, buckets skipped by --gaps still need to decay.
+	last time.Time
+}
+
+func (m *emaMetric) add(e event) {
+	m.bucketSum += float64(e.Duration)
+	m.bucketCount++
+}
+
+func (m *emaMetric) value(currBucket time.Time, queue windowQueue) float32 {
+	if !m.last.IsZero() {
+		if skipped := currBucket.Sub(m.last)/m.step - 1; skipped > 0 {
+			decay := math.Pow(1-m.alpha, float64(skipped))
+			m.sum *= decay
+			m.count *= decay
+		}
+	}
+	m.last = currBucket
+	m.sum = m.alpha*m.bucketSum + (1-m.alpha)*m.sum
+	m.count = m.alpha*m.bucketCount + (1-m.alpha)*m.count
+	m.bucketSum, m.bucketCount = 0, 0
+	if m.count > 0 {
+		return float32(m.sum / m.count)
+	}
+	return 0
+}
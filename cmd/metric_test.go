@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+	at := func(s string) resultKey {
+		date, err := time.Parse("2006-01-02 15:04:05", s)
+		require.NoError(t, err)
+		return resultKey{Date: date}
+	}
+
+	tcs := []struct {
+		name    string
+		opts    smaOptions
+		want    map[string]float32
+		wantErr error
+	}{
+		{
+			name: "when metric is sma should match the simple moving average",
+			opts: smaOptions{Window: 10 * time.Minute, Metric: "sma"},
+			want: map[string]float32{"2018-12-26 18:21:00": 25.5, "2018-12-26 18:24:00": 42.5},
+		},
+		{
+			name: "when metric is wma should weight recent minutes more",
+			opts: smaOptions{Window: 10 * time.Minute, Metric: "wma"},
+			// 18:21: (20*1 + 31*5) / 6, 18:24: (31*2 + 54*10) / 12
+			want: map[string]float32{"2018-12-26 18:21:00": 175.0 / 6, "2018-12-26 18:24:00": 602.0 / 12},
+		},
+		{
+			name: "when metric is ema with alpha 1 should be the last minute avg",
+			opts: smaOptions{Window: 10 * time.Minute, Metric: "ema", Alpha: 1},
+			want: map[string]float32{"2018-12-26 18:12:00": 20, "2018-12-26 18:16:00": 31, "2018-12-26 18:24:00": 54},
+		},
+		{
+			name: "when metric is ema with half-life should decay old minutes",
+			opts: smaOptions{Window: 10 * time.Minute, Metric: "ema", HalfLife: time.Minute},
+			// at 18:16 the 18:11 event was smoothed 4 times more than the 18:15 one: weights 1/16 and 1.
+			want: map[string]float32{"2018-12-26 18:16:00": (20.0/16 + 31) / (1.0/16 + 1)},
+		},
+		{
+			name:    "when metric is unknown should error",
+			opts:    smaOptions{Window: 10 * time.Minute, Metric: "unknown"},
+			wantErr: ErrUnknownMetric,
+		},
+		{
+			name:    "when alpha is out of range should error",
+			opts:    smaOptions{Window: 10 * time.Minute, Metric: "ema", Alpha: 2},
+			wantErr: ErrInvalidAlpha,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := collectSMA(&sliceSource{events: events}, engines["fifo"], tc.opts)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, got, 14)
+			for date, want := range tc.want {
+				require.InDelta(t, want, got[at(date)].AvgDeliveryTime, 0.0001, date)
+			}
+		})
+	}
+}
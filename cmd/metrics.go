@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricsPrefix is the name prefix of every metric in GET /metrics.
+const metricsPrefix = "sma_"
+
+// latestRows is a sink keeping the most recent row of each group,
+// which is what GET /metrics exposes as the current window.
+type latestRows struct {
+	mu   sync.Mutex
+	rows map[string]output
+	// emitted counts every row emitted, corrections included.
+	emitted int64
+}
+
+// newLatestRows creates an empty latestRows.
+func newLatestRows() *latestRows {
+	return &latestRows{rows: make(map[string]output)}
+}
+
+// Emit keeps the row if it's the most recent one of its group.
+// Corrections of older minutes are left out, the current window is already past them.
+func (l *latestRows) Emit(row output) error {
+	key := labelsKey(row.Labels)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.emitted++
+	if prev, ok := l.rows[key]; ok && row.Date.Before(prev.Date) {
+		return nil
+	}
+	l.rows[key] = row
+	return nil
+}
+
+// drop forgets the row of a group dropped once its window drained.
+func (l *latestRows) drop(labels []label) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.rows, labelsKey(labels))
+}
+
+// sorted returns the kept rows ordered by group.
+func (l *latestRows) sorted() []output {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := make([]string, 0, len(l.rows))
+	for key := range l.rows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rows := make([]output, 0, len(keys))
+	for _, key := range keys {
+		rows = append(rows, l.rows[key])
+	}
+	return rows
+}
+
+// count returns how many rows were emitted.
+func (l *latestRows) count() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.emitted
+}
+
+// labelsKey identifies a group by its labels.
+func labelsKey(labels []label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte('=')
+		b.WriteString(l.Value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// queueSize is a window queue size of a group, capacity is -1 for queues that don't have one.
+type queueSize struct {
+	labels   []label
+	length   int
+	capacity int
+}
+
+// promWriter writes metrics in the prometheus text format, families must be written one at a time.
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+// family writes the HELP and TYPE lines of a metric.
+func (p *promWriter) family(name, typ, help string) {
+	p.printf("# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+// sample writes a metric value, extra labels go after the group ones.
+func (p *promWriter) sample(name string, labels []label, value float64, extra ...label) {
+	var b strings.Builder
+	b.WriteString(metricsPrefix + name)
+	all := append(append([]label(nil), labels...), extra...)
+	if len(all) > 0 {
+		b.WriteByte('{')
+		for i, l := range all {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `%s="%s"`, promLabelName(l.Name), promEscaper.Replace(l.Value))
+		}
+		b.WriteByte('}')
+	}
+	p.printf("%s %s\n", b.String(), promValue(value))
+}
+
+// printf writes unless a previous write failed.
+func (p *promWriter) printf(format string, args ...any) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
+
+// promLabelName replaces chars prometheus doesn't allow in label names, e.g. a dot of a nested field.
+func promLabelName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// promEscaper escapes label values, prometheus only escapes backslashes, quotes and new lines.
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promValue formats a sample value, prometheus spells infinities and NaN its own way.
+func promValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// fieldValue returns a row field value as a float64.
+func fieldValue(v any) float64 {
+	switch v := v.(type) {
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	}
+	return math.NaN()
+}
+
+// promMetric is a metric without labels, e.g. how many events the server keeps.
+type promMetric struct {
+	name  string
+	typ   string
+	help  string
+	value float64
+}
+
+// writeMetrics writes the most recent rows, the queue sizes and the other metrics in the prometheus text format.
+// quantiles are the ones asked, so their fields can be told apart from stats.
+func writeMetrics(w io.Writer, rows []output, quantiles []float64, queues []queueSize, others []promMetric) error {
+	p := &promWriter{w: w}
+
+	byName := make(map[string]float64, len(quantiles))
+	for _, q := range quantiles {
+		byName[quantileName(q)] = q
+	}
+	// a row field is either a quantile or a stat, stats are a family each.
+	var stats []string
+	for _, row := range rows {
+		for _, f := range row.Fields {
+			if _, ok := byName[f.Name]; !ok && !containsString(stats, f.Name) {
+				stats = append(stats, f.Name)
+			}
+		}
+	}
+
+	p.family("average_delivery_time", "gauge", "The moving average of the delivery time in the current window.")
+	for _, row := range rows {
+		p.sample("average_delivery_time", row.Labels, rowValue(row, float64(row.AvgDeliveryTime)))
+	}
+
+	if len(quantiles) > 0 {
+		p.family("delivery_time_quantile", "gauge", "Quantiles of the delivery time in the current window.")
+		for _, row := range rows {
+			for _, f := range row.Fields {
+				if q, ok := byName[f.Name]; ok {
+					p.sample("delivery_time_quantile", row.Labels, rowValue(row, fieldValue(f.Value)), label{Name: "quantile", Value: strconv.FormatFloat(q, 'g', -1, 64)})
+				}
+			}
+		}
+	}
+
+	for _, name := range stats {
+		metric := "window_" + promLabelName(name)
+		if name == "count" {
+			metric = "window_events"
+		}
+		p.family(metric, "gauge", fmt.Sprintf("The %s of events in the current window.", name))
+		for _, row := range rows {
+			for _, f := range row.Fields {
+				if f.Name == name {
+					p.sample(metric, row.Labels, rowValue(row, fieldValue(f.Value)))
+				}
+			}
+		}
+	}
+
+	p.family("window_queue_length", "gauge", "How many events the window queue of a group holds.")
+	for _, q := range queues {
+		p.sample("window_queue_length", q.labels, float64(q.length))
+	}
+	p.family("window_queue_capacity", "gauge", "How many events fit in the window queue of a group before it grows, buffifo engine only.")
+	for _, q := range queues {
+		if q.capacity >= 0 {
+			p.sample("window_queue_capacity", q.labels, float64(q.capacity))
+		}
+	}
+
+	for _, m := range others {
+		p.family(m.name, m.typ, m.help)
+		p.sample(m.name, nil, m.value)
+	}
+	return p.err
+}
+
+// rowValue is NaN for null rows, the ones starting a gap with --gaps null.
+func rowValue(row output, v float64) float64 {
+	if row.null {
+		return math.NaN()
+	}
+	return v
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	date := time.Date(2018, 12, 26, 18, 24, 0, 0, time.UTC)
+	labels := []label{{Name: "client_name", Value: `air"liberty`}, {Name: "source_language", Value: "en"}}
+	rows := []output{
+		{Date: date, Labels: labels, AvgDeliveryTime: 25.5, Fields: []field{{Name: "count", Value: int64(2)}, {Name: "p90", Value: float32(31)}}},
+		{Date: date, Labels: []label{{Name: "client_name", Value: "taxi-eats"}, {Name: "source_language", Value: "en"}}, null: true},
+	}
+	queues := []queueSize{{labels: labels, length: 2, capacity: 16}, {labels: rows[1].Labels, length: 0, capacity: -1}}
+
+	var buf bytes.Buffer
+	err := writeMetrics(&buf, rows, []float64{0.9}, queues, []promMetric{{name: "stored_events", typ: "gauge", help: "Stored events.", value: 3}})
+	require.NoError(t, err)
+	require.Equal(t, `# HELP sma_average_delivery_time The moving average of the delivery time in the current window.
+# TYPE sma_average_delivery_time gauge
+sma_average_delivery_time{client_name="air\"liberty",source_language="en"} 25.5
+sma_average_delivery_time{client_name="taxi-eats",source_language="en"} NaN
+# HELP sma_delivery_time_quantile Quantiles of the delivery time in the current window.
+# TYPE sma_delivery_time_quantile gauge
+sma_delivery_time_quantile{client_name="air\"liberty",source_language="en",quantile="0.9"} 31
+# HELP sma_window_events The count of events in the current window.
+# TYPE sma_window_events gauge
+sma_window_events{client_name="air\"liberty",source_language="en"} 2
+# HELP sma_window_queue_length How many events the window queue of a group holds.
+# TYPE sma_window_queue_length gauge
+sma_window_queue_length{client_name="air\"liberty",source_language="en"} 2
+sma_window_queue_length{client_name="taxi-eats",source_language="en"} 0
+# HELP sma_window_queue_capacity How many events fit in the window queue of a group before it grows, buffifo engine only.
+# TYPE sma_window_queue_capacity gauge
+sma_window_queue_capacity{client_name="air\"liberty",source_language="en"} 16
+# HELP sma_stored_events Stored events.
+# TYPE sma_stored_events gauge
+sma_stored_events 3
+`, buf.String())
+}
+
+func TestLatestRows(t *testing.T) {
+	base := time.Date(2018, 12, 26, 18, 24, 0, 0, time.UTC)
+	labels := []label{{Name: "client_name", Value: "airliberty"}}
+	latest := newLatestRows()
+	require.NoError(t, latest.Emit(output{Date: base, Labels: labels, AvgDeliveryTime: 1}))
+	require.NoError(t, latest.Emit(output{Date: base.Add(-time.Minute), Labels: labels, AvgDeliveryTime: 2, correction: true}))
+	require.NoError(t, latest.Emit(output{Date: base, Labels: labels, AvgDeliveryTime: 3, correction: true}))
+
+	rows := latest.sorted()
+	require.Len(t, rows, 1)
+	require.Equal(t, float32(3), rows[0].AvgDeliveryTime, "corrections of older minutes are left out")
+	require.Equal(t, int64(3), latest.count())
+	require.Equal(t, "NaN", promValue(math.NaN()))
+}
+
+func TestServerMetrics(t *testing.T) {
+	srv := newServer(newEventStore(0, 24*time.Hour), engines["buffifo"], 1<<20)
+	require.NoError(t, srv.startLive(smaOptions{
+		Window:    10 * time.Minute,
+		GroupBy:   []string{"client_name", "source_language", "target_language"},
+		Stats:     []string{"count"},
+		Quantiles: []float64{0.5},
+		Late:      lateDrop,
+	}, 16, 60))
+	ts := httptest.NewServer(srv.routes())
+	t.Cleanup(ts.Close)
+
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	status, _ := doRequest(t, http.MethodPost, ts.URL+"/events", string(input))
+	require.Equal(t, http.StatusAccepted, status)
+	status, _ = doRequest(t, http.MethodPost, ts.URL+"/events", `{"timestamp":"2018-12-26 18:25:00","client_name":"airliberty","source_language":"en","target_language":"fr","duration":1}`)
+	require.Equal(t, http.StatusAccepted, status)
+
+	status, body := doRequest(t, http.MethodGet, ts.URL+"/metrics", "")
+	require.Equal(t, http.StatusOK, status)
+	lines := strings.Split(body, "\n")
+	for _, want := range []string{
+		`sma_average_delivery_time{client_name="airliberty",source_language="en",target_language="fr"} 31`,
+		`sma_average_delivery_time{client_name="taxi-eats",source_language="en",target_language="fr"} 54`,
+		`sma_delivery_time_quantile{client_name="airliberty",source_language="en",target_language="fr",quantile="0.5"} 31`,
+		`sma_window_events{client_name="airliberty",source_language="en",target_language="fr"} 1`,
+		`sma_window_queue_length{client_name="taxi-eats",source_language="en",target_language="fr"} 1`,
+		`sma_window_queue_capacity{client_name="taxi-eats",source_language="en",target_language="fr"} 16`,
+		`sma_stored_events 4`,
+		`sma_stream_subscribers 0`,
+	} {
+		require.Contains(t, lines, want)
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrInvalidOnError = errors.New("invalid --on-error")
+
+// --on-error policies for invalid events.
+const (
+	onErrorFail       = "fail"
+	onErrorSkip       = "skip"
+	onErrorDeadletter = "deadletter"
+)
+
+// maxReportedErrors is how many skipped events are listed in the summary, the others are only counted.
+const maxReportedErrors = 10
+
+// parseOnError parses the --on-error flag, it returns the policy and the dead letter file.
+func parseOnError(value string) (string, string, error) {
+	policy, path, _ := strings.Cut(value, "=")
+	switch {
+	case policy == "" || policy == onErrorFail || policy == onErrorSkip:
+		if path != "" {
+			return "", "", fmt.Errorf("%w: %s takes no file, only %s does", ErrInvalidOnError, policy, onErrorDeadletter)
+		}
+		if policy == "" {
+			policy = onErrorFail
+		}
+		return policy, "", nil
+	case policy == onErrorDeadletter:
+		if path == "" {
+			return "", "", fmt.Errorf("%w: %s needs a file, e.g. %s=bad.json", ErrInvalidOnError, onErrorDeadletter, onErrorDeadletter)
+		}
+		return policy, path, nil
+	}
+	return "", "", fmt.Errorf("%w %q, use one of: %s, %s, %s=<file>", ErrInvalidOnError, value, onErrorFail, onErrorSkip, onErrorDeadletter)
+}
+
+// deadLetter is a line of the dead letter file, an invalid event and why.
+type deadLetter struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Offset int64  `json:"offset"`
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+	// Record is the event as it was in the input, it may not even be json.
+	Record string `json:"record"`
+}
+
+// errorPolicy handles the invalid events of the sources, see errorSource.
+type errorPolicy struct {
+	policy string
+	// deadLetters is where invalid events are written with deadletter.
+	deadLetters *bufio.Writer
+	skipped     int
+	// reported are the first skipped events, for the summary.
+	reported []error
+}
+
+// newErrorPolicy creates an errorPolicy, deadLetters is only used with deadletter.
+func newErrorPolicy(policy string, deadLetters io.Writer) *errorPolicy {
+	p := &errorPolicy{policy: policy}
+	if policy == onErrorDeadletter {
+		p.deadLetters = bufio.NewWriter(deadLetters)
+	}
+	return p
+}
+
+// handle returns nil when the invalid event err is about is skipped, or else err.
+// Errors that aren't about an event, e.g. the input can't be read, are never skipped,
+// and neither are the ones the input can't be read past.
+func (p *errorPolicy) handle(err error) error {
+	var eventErr *eventError
+	if p.policy == onErrorFail || !errors.As(err, &eventErr) || eventErr.fatal {
+		return err
+	}
+
+	p.skipped++
+	if len(p.reported) < maxReportedErrors {
+		p.reported = append(p.reported, err)
+	}
+	if p.deadLetters == nil {
+		return nil
+	}
+	bs, merr := json.Marshal(deadLetter{
+		File:   eventErr.file,
+		Line:   eventErr.line,
+		Offset: eventErr.offset,
+		Field:  eventErr.field,
+		Reason: eventErr.err.Error(),
+		Record: string(eventErr.record),
+	})
+	if merr != nil {
+		return merr
+	}
+	if _, werr := p.deadLetters.Write(append(bs, '\n')); werr != nil {
+		return fmt.Errorf("%w: %v", ErrWriteOutput, werr)
+	}
+	return nil
+}
+
+// Flush writes buffered dead letters.
+func (p *errorPolicy) Flush() error {
+	if p.deadLetters == nil {
+		return nil
+	}
+	return p.deadLetters.Flush()
+}
+
+// summary writes how many events were skipped and the first ones.
+func (p *errorPolicy) summary(w io.Writer) {
+	if p.skipped == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%d invalid events skipped\n", p.skipped)
+	for _, err := range p.reported {
+		fmt.Fprintf(w, "  %v\n", err)
+	}
+	if more := p.skipped - len(p.reported); more > 0 {
+		fmt.Fprintf(w, "  and %d more\n", more)
+	}
+}
+
+// errorSource tells which file the invalid events of src are in, and skips them
+// when the policy says so.
+type errorSource struct {
+	src    eventSource
+	file   string
+	policy *errorPolicy
+}
+
+// Next returns the next valid event, or the first error the policy doesn't skip.
+func (s *errorSource) Next() (event, error) {
+	for {
+		e, err := s.src.Next()
+		if err == nil || err == io.EOF {
+			return e, err
+		}
+		var eventErr *eventError
+		if errors.As(err, &eventErr) && s.file != stdioName {
+			eventErr.file = s.file
+		}
+		if err := s.policy.handle(err); err != nil {
+			return event{}, err
+		}
+	}
+}
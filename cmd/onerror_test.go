@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// badEvents is ndjson with a valid event, a blank line, and then an event of each kind of error.
+const badEvents = `{"timestamp":"2018-12-26 18:11:08.509654","duration":20}
+
+{"timestamp":"2018-12-26 18:12:08.509654","duration":"fast"}
+{"timestamp":"26/12/2018","duration":20}
+  {"timestamp":"2018-12-26 18:13:08.509654","duration":-3}
+{"timestamp":"2018-12-26 18:14:08.509654",
+{"timestamp":"2018-12-26 18:15:08.509654","duration":31}
+`
+
+func TestParseOnError(t *testing.T) {
+	tcs := []struct {
+		name       string
+		value      string
+		wantPolicy string
+		wantPath   string
+		wantErr    error
+	}{
+		{name: "when value is empty should fail", value: "", wantPolicy: onErrorFail},
+		{name: "when value is skip should skip", value: "skip", wantPolicy: onErrorSkip},
+		{name: "when value is deadletter should have the file", value: "deadletter=bad.json", wantPolicy: onErrorDeadletter, wantPath: "bad.json"},
+		{name: "when deadletter has no file should error", value: "deadletter", wantErr: ErrInvalidOnError},
+		{name: "when skip has a file should error", value: "skip=bad.json", wantErr: ErrInvalidOnError},
+		{name: "when value is unknown should error", value: "ignore", wantErr: ErrInvalidOnError},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			policy, path, err := parseOnError(tc.value)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.wantPolicy, policy)
+			require.Equal(t, tc.wantPath, path)
+		})
+	}
+}
+
+func TestErrorSource(t *testing.T) {
+	var deadLetters bytes.Buffer
+	policy := newErrorPolicy(onErrorDeadletter, &deadLetters)
+	src := &errorSource{src: newJSONSource(strings.NewReader(badEvents)), file: "events.ndjson", policy: policy}
+	got, err := readAll(src)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Equal(t, 31, got[1].Duration)
+	require.NoError(t, policy.Flush())
+	require.Equal(t, 4, policy.skipped)
+
+	lines := strings.Split(strings.TrimSpace(deadLetters.String()), "\n")
+	require.Len(t, lines, 4)
+	want := []deadLetter{
+		{File: "events.ndjson", Line: 3, Offset: 58, Field: "duration", Reason: "invalid event: got a string, expected int", Record: `{"timestamp":"2018-12-26 18:12:08.509654","duration":"fast"}`},
+		{File: "events.ndjson", Line: 4, Offset: 119, Field: "timestamp"},
+		{File: "events.ndjson", Line: 5, Offset: 162, Field: "duration", Reason: "invalid event: negative duration -3", Record: `{"timestamp":"2018-12-26 18:13:08.509654","duration":-3}`},
+		{File: "events.ndjson", Line: 6, Offset: 219, Record: `{"timestamp":"2018-12-26 18:14:08.509654",`},
+	}
+	for i, line := range lines {
+		var got deadLetter
+		require.NoError(t, json.Unmarshal([]byte(line), &got))
+		require.Equal(t, want[i].File, got.File, line)
+		require.Equal(t, want[i].Line, got.Line, line)
+		require.Equal(t, want[i].Offset, got.Offset, line)
+		require.Equal(t, want[i].Field, got.Field, line)
+		require.NotEmpty(t, got.Reason, line)
+		if want[i].Reason != "" {
+			require.Equal(t, want[i].Reason, got.Reason, line)
+		}
+		if want[i].Record != "" {
+			require.Equal(t, want[i].Record, got.Record, line)
+		}
+		require.Equal(t, want[i].Record, badEvents[got.Offset:int(got.Offset)+len(want[i].Record)], "offset is where the record starts")
+	}
+
+	// fail stops at the first invalid event, and says where it is.
+	src = &errorSource{src: newJSONSource(strings.NewReader(badEvents)), file: "events.ndjson", policy: newErrorPolicy(onErrorFail, nil)}
+	_, err = readAll(src)
+	require.ErrorIs(t, err, ErrInvalidEvent)
+	require.EqualError(t, err, "events.ndjson: line 3, byte 58: duration: invalid event: got a string, expected int")
+
+	// a broken array can't be skipped.
+	src = &errorSource{src: newJSONSource(strings.NewReader(`[{"timestamp":"2018-12-26 18:11:08.509654"} {}]`)), file: "-", policy: newErrorPolicy(onErrorSkip, nil)}
+	_, err = readAll(src)
+	require.ErrorIs(t, err, ErrInvalidEvent)
+	require.EqualError(t, err, "line 1, byte 45: invalid character '{' after array element")
+}
+
+func TestJSONSourceResumeLines(t *testing.T) {
+	src := newJSONSource(strings.NewReader(badEvents))
+	_, err := src.Next()
+	require.NoError(t, err)
+	offset := src.Offset()
+
+	resumed, err := resumeJSONSource(strings.NewReader(badEvents[offset:]), offset, src.Lines(), false)
+	require.NoError(t, err)
+	_, err = resumed.Next()
+	require.ErrorContains(t, err, "line 3, byte 58: ")
+}
+
+func TestRootOnError(t *testing.T) {
+	dir := t.TempDir()
+	input, deadLetters := filepath.Join(dir, "events.ndjson"), filepath.Join(dir, "bad.json")
+	require.NoError(t, os.WriteFile(input, []byte(badEvents), 0o644))
+	defer func() {
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+		require.NoError(t, rootCmd.Flags().Set(ON_ERROR_FLAG, onErrorFail))
+	}()
+
+	rootCmd.SetArgs([]string{"--input_file=" + input, "--output=-", "--window_size=10"})
+	rootCmd.SetOut(io.Discard)
+	err := rootCmd.Execute()
+	require.ErrorIs(t, err, ErrParseInputFile)
+	require.ErrorIs(t, err, ErrInvalidEvent)
+	require.ErrorContains(t, err, input+": line 3, byte 58: duration: ")
+
+	var stdout, stderr bytes.Buffer
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetErr(&stderr)
+	rootCmd.SetArgs([]string{"--input_file=" + input, "--output=-", "--window_size=10", "--on-error=deadletter=" + deadLetters})
+	require.NoError(t, rootCmd.Execute())
+	require.Equal(t, `{"date":"2018-12-26 18:16:00","average_delivery_time":25.5}`, lastLine(stdout.String()))
+	require.Contains(t, stderr.String(), "4 invalid events skipped\n")
+	require.Len(t, strings.Split(strings.TrimSpace(mustReadFile(t, deadLetters)), "\n"), 4)
+
+	rootCmd.SetArgs([]string{"--input_file=" + input, "--output=-", "--on-error=ignore"})
+	require.ErrorIs(t, rootCmd.Execute(), ErrInvalidOnError)
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
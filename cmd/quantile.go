@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+var ErrInvalidQuantile = errors.New("quantiles must be in [0, 1]")
+var ErrUnknownQuantileMode = errors.New("unknown quantile mode")
+var ErrInvalidAccuracy = errors.New("quantile accuracy must be in (0, 1)")
+
+// quantileWindow keeps the durations of a window so quantiles can be queried,
+// values are added when events are enqueued and removed when they are evicted.
+type quantileWindow interface {
+	add(v float64)
+	remove(v float64)
+	// quantile returns the q quantile, or 0 for an empty window like the avg does.
+	quantile(q float64) float64
+}
+
+// quantileWindowFactory returns the constructor of the quantile window for the given mode:
+// exact keeps every value sorted, approx uses a DDSketch with the given relative accuracy.
+func quantileWindowFactory(mode string, accuracy float64) (func() quantileWindow, error) {
+	switch mode {
+	case "", "exact":
+		return func() quantileWindow { return &exactQuantiles{} }, nil
+	case "approx":
+		if accuracy <= 0 || accuracy >= 1 {
+			return nil, ErrInvalidAccuracy
+		}
+		return func() quantileWindow { return newDDSketch(accuracy) }, nil
+	}
+	return nil, fmt.Errorf("%w %q, use one of: approx, exact", ErrUnknownQuantileMode, mode)
+}
+
+// validateQuantiles checks all quantiles are in [0, 1].
+func validateQuantiles(qs []float64) error {
+	for _, q := range qs {
+		if q < 0 || q > 1 || math.IsNaN(q) {
+			return fmt.Errorf("%w, got %v", ErrInvalidQuantile, q)
+		}
+	}
+	return nil
+}
+
+// quantileName is the output field name of a quantile, e.g. 0.5 is p50 and 0.999 is p99.9.
+func quantileName(q float64) string {
+	// round away float noise, 0.29*100 is 28.999999999999996.
+	p := math.Round(q*100*1e6) / 1e6
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// exactQuantiles keeps window values in a sorted slice.
+// add and remove are O(n) due to the copy, but n is only the window size.
+type exactQuantiles struct {
+	values []float64
+}
+
+func (e *exactQuantiles) add(v float64) {
+	i := sort.SearchFloat64s(e.values, v)
+	e.values = append(e.values, 0)
+	copy(e.values[i+1:], e.values[i:])
+	e.values[i] = v
+}
+
+func (e *exactQuantiles) remove(v float64) {
+	i := sort.SearchFloat64s(e.values, v)
+	if i < len(e.values) && e.values[i] == v {
+		e.values = append(e.values[:i], e.values[i+1:]...)
+	}
+}
+
+// quantile interpolates linearly between the closest ranks.
+func (e *exactQuantiles) quantile(q float64) float64 {
+	n := len(e.values)
+	if n == 0 {
+		return 0
+	}
+	pos := q * float64(n-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	frac := pos - float64(lower)
+	return e.values[lower] + frac*(e.values[upper]-e.values[lower])
+}
+
+// ddSketch is a DDSketch(https://arxiv.org/abs/1908.10693): values are counted in
+// logarithmic buckets, so any quantile is within the relative accuracy of the real one
+// while memory only depends on the range of values, not on how many there are.
+// Removing a value is just decrementing its bucket, which is what makes it fit a sliding window.
+type ddSketch struct {
+	gamma    float64
+	logGamma float64
+	buckets  map[int]int
+	// zeros counts values <= 0, they have no log bucket.
+	zeros int
+	count int
+}
+
+// newDDSketch creates a ddSketch with the given relative accuracy, e.g. 0.01 for 1%.
+func newDDSketch(accuracy float64) *ddSketch {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &ddSketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		buckets:  make(map[int]int),
+	}
+}
+
+func (s *ddSketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+func (s *ddSketch) add(v float64) {
+	s.count++
+	if v <= 0 {
+		s.zeros++
+		return
+	}
+	s.buckets[s.index(v)]++
+}
+
+func (s *ddSketch) remove(v float64) {
+	if v <= 0 {
+		if s.zeros > 0 {
+			s.zeros--
+			s.count--
+		}
+		return
+	}
+	i := s.index(v)
+	if s.buckets[i] == 0 {
+		return
+	}
+	s.count--
+	s.buckets[i]--
+	if s.buckets[i] == 0 {
+		delete(s.buckets, i)
+	}
+}
+
+func (s *ddSketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := int(math.Round(q * float64(s.count-1)))
+	if rank < s.zeros {
+		return 0
+	}
+	seen := s.zeros
+
+	// there are only a few buckets, log(max/min)/log(gamma), so sorting them is cheap.
+	keys := make([]int, 0, len(s.buckets))
+	for k := range s.buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	for _, k := range keys {
+		seen += s.buckets[k]
+		if seen > rank {
+			// the bucket midpoint in relative terms.
+			return 2 * math.Pow(s.gamma, float64(k)) / (s.gamma + 1)
+		}
+	}
+	return 2 * math.Pow(s.gamma, float64(keys[len(keys)-1])) / (s.gamma + 1)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuantileName(t *testing.T) {
+	require.Equal(t, "p50", quantileName(0.5))
+	require.Equal(t, "p99", quantileName(0.99))
+	require.Equal(t, "p99.9", quantileName(0.999))
+	require.Equal(t, "p29", quantileName(0.29))
+}
+
+func TestExactQuantiles(t *testing.T) {
+	q := &exactQuantiles{}
+	require.Equal(t, float64(0), q.quantile(0.5))
+
+	for _, v := range []float64{5, 1, 4, 2, 3} {
+		q.add(v)
+	}
+	require.Equal(t, float64(3), q.quantile(0.5))
+	require.Equal(t, float64(1), q.quantile(0))
+	require.Equal(t, float64(5), q.quantile(1))
+	require.InDelta(t, 4.6, q.quantile(0.9), 0.0001)
+
+	// evicting the smallest values moves the median up.
+	q.remove(1)
+	q.remove(2)
+	require.Equal(t, float64(4), q.quantile(0.5))
+}
+
+func TestDDSketchAccuracy(t *testing.T) {
+	accuracy := 0.01
+	exact := &exactQuantiles{}
+	sketch := newDDSketch(accuracy)
+
+	values := make([]float64, 10000)
+	for i := range values {
+		values[i] = float64(rand.Intn(120) + 1)
+		exact.add(values[i])
+		sketch.add(values[i])
+	}
+	// slide the window: drop the first half.
+	for _, v := range values[:5000] {
+		exact.remove(v)
+		sketch.remove(v)
+	}
+
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		want := exact.quantile(q)
+		require.InEpsilon(t, want, sketch.quantile(q), 2*accuracy, "quantile %v", q)
+	}
+}
+
+func TestStreamSMAQuantiles(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+	date, err := time.Parse("2006-01-02 15:04:05", "2018-12-26 18:24:00")
+	require.NoError(t, err)
+
+	got, err := collectSMA(&sliceSource{events: events}, engines["buffifo"], smaOptions{
+		Window:    10 * time.Minute,
+		Quantiles: []float64{0.5, 0.9},
+	})
+	require.NoError(t, err)
+	// window at 18:24 has durations 31 and 54.
+	require.Equal(t, []field{{Name: "p50", Value: float32(42.5)}, {Name: "p90", Value: float32(51.7)}}, got[resultKey{Date: date}].Fields)
+
+	_, err = collectSMA(&sliceSource{events: events}, engines["fifo"], smaOptions{Window: 10 * time.Minute, Quantiles: []float64{1.5}})
+	require.ErrorIs(t, err, ErrInvalidQuantile)
+}
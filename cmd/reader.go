@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// eventSource yields translation events one at a time, so the sma loop never
+// needs the whole input in memory. Next returns io.EOF when there are no more events.
+type eventSource interface {
+	Next() (event, error)
+}
+
+// jsonSource decodes events from a reader holding either a top-level json array
+// or newline delimited json(one event per line).
+type jsonSource struct {
+	r       *bufio.Reader
+	dec     *json.Decoder
+	started bool
+	inArray bool
+	// base and skipped turn the decoder offset into an input offset: base is where the
+	// decoder input starts, and skipped the blanks detect read before the decoder.
+	base    int64
+	skipped int64
+	// start is the input offset r starts at, lines are counted from there.
+	start int64
+	lines *lineCounter
+}
+
+// newJSONSource creates a jsonSource reading from r.
+func newJSONSource(r io.Reader) *jsonSource {
+	lines := &lineCounter{r: r}
+	br := bufio.NewReader(lines)
+	return &jsonSource{
+		r:     br,
+		dec:   json.NewDecoder(br),
+		lines: lines,
+	}
+}
+
+// resumeJSONSource creates a jsonSource carrying on from offset, a jsonSource.Offset of
+// a previous run over the same input, with lines the jsonSource.Lines of it.
+// r must already be at offset.
+func resumeJSONSource(r io.Reader, offset int64, lines int, inArray bool) (*jsonSource, error) {
+	if offset == 0 {
+		return newJSONSource(r), nil
+	}
+	if !inArray {
+		s := newJSONSource(r)
+		s.started, s.base, s.start = true, offset, offset
+		s.lines.lines = lines
+		return s, nil
+	}
+
+	// the decoder has to see the array start, so we hand it a '[' in place of the
+	// comma between the last event read and the next one.
+	counter := &lineCounter{r: r, lines: lines}
+	br := bufio.NewReader(counter)
+	skipped := int64(0)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == ',' {
+			skipped++
+			continue
+		}
+		if err := br.UnreadByte(); err != nil {
+			return nil, err
+		}
+		break
+	}
+	dec := json.NewDecoder(io.MultiReader(strings.NewReader("["), br))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return &jsonSource{r: br, dec: dec, started: true, inArray: true, base: offset + skipped - 1, start: offset, lines: counter}, nil
+}
+
+// Offset returns how many input bytes were read up to the end of the last event.
+func (s *jsonSource) Offset() int64 {
+	return s.base + s.skipped + s.dec.InputOffset()
+}
+
+// Lines returns how many new lines are before Offset.
+func (s *jsonSource) Lines() int {
+	return s.lines.before(s.Offset() - s.start)
+}
+
+// lineAt returns the line of an input offset, offsets can't go backwards.
+func (s *jsonSource) lineAt(offset int64) int {
+	return s.lines.before(offset-s.start) + 1
+}
+
+// Next decodes the next event from the input.
+func (s *jsonSource) Next() (event, error) {
+	if !s.started {
+		s.started = true
+		if err := s.detect(); err != nil {
+			return event{}, err
+		}
+	}
+
+	if s.inArray && !s.dec.More() {
+		// consume the closing ']'.
+		if _, err := s.dec.Token(); err != nil {
+			return event{}, err
+		}
+		return event{}, io.EOF
+	}
+
+	// events are decoded in two steps, so an invalid one is skipped like it was never read,
+	// and we still have it for the error.
+	var raw json.RawMessage
+	// for ndjson Decode returns io.EOF on a clean end of input.
+	if err := s.dec.Decode(&raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		isSyntax := errors.As(err, &syntaxErr)
+		if !s.inArray && (isSyntax || err == io.ErrUnexpectedEOF) {
+			return event{}, s.skipLine(err)
+		}
+		// a broken array can't be read any further.
+		if isSyntax || err == io.ErrUnexpectedEOF {
+			offset := s.Offset()
+			if isSyntax {
+				offset = s.base + s.skipped + syntaxErr.Offset
+			}
+			eventErr := newEventError(err, s.lineAt(offset), offset, nil)
+			eventErr.fatal = true
+			return event{}, eventErr
+		}
+		return event{}, err
+	}
+
+	start := s.Offset() - int64(len(raw))
+	line := s.lineAt(start)
+	// the decoder already checked raw is valid json.
+	var e event
+	if err := e.UnmarshalJSON(raw); err != nil {
+		return event{}, newEventError(err, line, start, raw)
+	}
+	return e, nil
+}
+
+// skipLine skips the broken event the decoder stopped at, up to the end of its line,
+// and returns err as an eventError. A new decoder carries on from the next line.
+func (s *jsonSource) skipLine(err error) error {
+	r := bufio.NewReader(io.MultiReader(s.dec.Buffered(), s.r))
+	start := s.Offset()
+	for {
+		b, rerr := r.ReadByte()
+		if rerr != nil {
+			break
+		}
+		if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
+			r.UnreadByte()
+			break
+		}
+		start++
+	}
+	line, _ := r.ReadBytes('\n')
+	eventErr := newEventError(err, s.lineAt(start), start, bytes.TrimRight(line, "\r\n"))
+
+	s.r, s.dec = r, json.NewDecoder(r)
+	s.base, s.skipped = start+int64(len(line)), 0
+	return eventErr
+}
+
+// detect peeks the first non blank byte to find out if input is a json array,
+// in that case the opening '[' is consumed so each Decode call returns one element.
+func (s *jsonSource) detect() error {
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == ' ' || b == '\t' || b == '\n' || b == '\r' {
+			s.skipped++
+			continue
+		}
+		if err := s.r.UnreadByte(); err != nil {
+			return err
+		}
+		if b != '[' {
+			return nil
+		}
+		break
+	}
+
+	if _, err := s.dec.Token(); err != nil {
+		return err
+	}
+	s.inArray = true
+	return nil
+}
+
+// lineCounter counts the new lines read from r, so events can be told by their line.
+// Decoders read ahead, so the new lines read past the last offset asked about are kept
+// until they're behind.
+type lineCounter struct {
+	r    io.Reader
+	read int64
+	// lines is how many new lines are behind, ahead are the offsets of the others.
+	lines int
+	ahead []int64
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i := 0; i < n; {
+		j := bytes.IndexByte(p[i:n], '\n')
+		if j < 0 {
+			break
+		}
+		c.ahead = append(c.ahead, c.read+int64(i+j))
+		i += j + 1
+	}
+	c.read += int64(n)
+	return n, err
+}
+
+// before returns how many new lines are before offset.
+func (c *lineCounter) before(offset int64) int {
+	i := 0
+	for i < len(c.ahead) && c.ahead[i] < offset {
+		i++
+	}
+	c.lines += i
+	c.ahead = c.ahead[i:]
+	return c.lines
+}
+
+// sliceSource is an eventSource over events already in memory.
+type sliceSource struct {
+	events []event
+	index  int
+}
+
+// Next returns the next event in the slice.
+func (s *sliceSource) Next() (event, error) {
+	if s.index >= len(s.events) {
+		return event{}, io.EOF
+	}
+	e := s.events[s.index]
+	s.index++
+	return e, nil
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONSource(t *testing.T) {
+	tcs := []struct {
+		name      string
+		input     string
+		wantCount int
+		wantErr   bool
+	}{
+		{
+			name: "when input is a json array should decode all events",
+			input: `[
+				{"timestamp": "2018-12-26 18:11:08.509654", "duration": 20},
+				{"timestamp": "2018-12-26 18:15:19.903159", "duration": 31}
+			]`,
+			wantCount: 2,
+		},
+		{
+			name: "when input is ndjson should decode all events",
+			input: `{"timestamp": "2018-12-26 18:11:08.509654", "duration": 20}
+{"timestamp": "2018-12-26 18:15:19.903159", "duration": 31}
+{"timestamp": "2018-12-26 18:23:19.903159", "duration": 54}
+`,
+			wantCount: 3,
+		},
+		{
+			name:      "when input is empty should return no events",
+			input:     "  \n",
+			wantCount: 0,
+		},
+		{
+			name:      "when input is an empty array should return no events",
+			input:     "[]",
+			wantCount: 0,
+		},
+		{
+			name:    "when input is invalid should error",
+			input:   `{"timestamp": "2018-12-26 18:11:08.509654", "duration": 20} {`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			src := newJSONSource(strings.NewReader(tc.input))
+			count := 0
+			var err error
+			for {
+				_, err = src.Next()
+				if err != nil {
+					break
+				}
+				count++
+			}
+			if tc.wantErr {
+				require.NotErrorIs(t, err, io.EOF)
+				return
+			}
+			require.ErrorIs(t, err, io.EOF)
+			require.Equal(t, tc.wantCount, count)
+		})
+	}
+}
+
+func TestStreamSMA(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+
+	got, err := collectSMA(&sliceSource{events: events}, engines["fifo"], smaOptions{Window: 10 * time.Minute})
+	require.NoError(t, err)
+	require.Equal(t, createWantResult(), got)
+}
@@ -1,19 +1,90 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 const (
-	INPUT_FILE_FLAG = "input_file"
-	WINDOW_FLAG     = "window"
+	INPUT_FILE_FLAG         = "input_file"
+	WINDOW_FLAG             = "window"
+	OUTPUT_FLAG             = "output"
+	ENGINE_FLAG             = "engine"
+	GROUP_BY_FLAG           = "group-by"
+	FILTER_FLAG             = "filter"
+	METRIC_FLAG             = "metric"
+	ALPHA_FLAG              = "alpha"
+	HALF_LIFE_FLAG          = "half-life"
+	QUANTILES_FLAG          = "quantiles"
+	QUANTILE_MODE_FLAG      = "quantile-mode"
+	QUANTILE_ACCURACY_FLAG  = "quantile-accuracy"
+	STATS_FLAG              = "stats"
+	STEP_FLAG               = "step"
+	GAPS_FLAG               = "gaps"
+	SORT_FLAG               = "sort"
+	SORT_RUN_FLAG           = "sort-run"
+	SORT_DIR_FLAG           = "sort-dir"
+	ALLOWED_LATENESS_FLAG   = "allowed-lateness"
+	LATE_FLAG               = "late"
+	LATE_EVENTS_FLAG        = "late-events"
+	ON_ERROR_FLAG           = "on-error"
+	FOLLOW_FLAG             = "follow"
+	CHECKPOINT_FLAG         = "checkpoint"
+	CHECKPOINT_EVERY_FLAG   = "checkpoint-every"
+	RESUME_FLAG             = "resume"
+	INPUT_FORMAT_FLAG       = "input-format"
+	DELIMITER_FLAG          = "delimiter"
+	COLUMNS_FLAG            = "columns"
+	OUTPUT_FORMAT_FLAG      = "output-format"
+	DATE_FORMAT_FLAG        = "date-format"
+	OUTPUT_COMPRESSION_FLAG = "output-compression"
 )
 
 var (
-	inputFile string
-	window    int32
+	inputFile         string
+	window            int32
+	windowDuration    durationValue
+	step              = durationValue(time.Minute)
+	gaps              string
+	outputFile        string
+	engine            string
+	groupBy           []string
+	filterExpr        string
+	metricName        string
+	alpha             float64
+	halfLife          time.Duration
+	quantiles         []float64
+	quantileMode      string
+	quantileAccuracy  float64
+	stats             []string
+	sortMode          string
+	sortRun           int
+	sortDir           string
+	allowedLateness   durationValue
+	late              string
+	lateEvents        string
+	follow            bool
+	checkpointPath    string
+	checkpointEvery   int
+	resume            bool
+	inputFormat       string
+	csvDelimiter      string
+	columns           map[string]string
+	outputFormat      string
+	dateFormat        string
+	outputCompression string
+	onError           string
 )
 
 var ErrInvalidWindow = errors.New("window must be a positive integer")
@@ -24,30 +95,411 @@ var rootCmd = &cobra.Command{
 	Use:   "calculator-cli",
 	Short: "Calculates the simple moving average(sma) from input data in a given period of time",
 	Long: `Calculator-cli will calculate the simple moving average(sma) from a input file in
-	in the .json format, the file should be indentified with --input_file flag.
+	in the .json format(a json array or one event per line), the file should be indentified with --input_file flag.
+	csv and tsv files with a header row are read too, see --input-format, --delimiter and --columns.
+	gzip, zstd and bzip2 compressed files are decompressed while they're read.
+	Rows are written as json lines, use --output-format json, csv, tsv or table for other formats,
+	and --date-format to change the date layout.
 	The time window to be considered in the sma calculation, e.g. 10 min, should be identified by
-	flag --window_size.
-	The output will be printed in the stdout.
-	calculator_cli --input_file events.json --window_size 10`,
+	flag --window_size, or as a duration, e.g. 15m, 2h or 1d, with --window.
+	There's an output row for each --step, a minute by default, e.g. 10s or 1h for other granularities.
+	With --gaps skip or null, empty stretches between distant events are skipped instead of
+	written as zeros, and run time depends on the events and not on the time they span.
+	The output will be written to the file given by --output(./result.txt by default),
+	use --input_file - to read from stdin and --output - to print it in the stdout.
+	calculator_cli --input_file events.json --window_size 10
+	The sma engine can be chosen with --engine, fifo is the fastest and buffifo uses less memory.
+	Use --group-by to get a separate sma for each client, language pair or any other event field.
+	Use --filter to only consider events matching an expression.
+	Use --metric to calculate an exponential(ema) or linearly weighted(wma) moving average instead,
+	the ema smoothing factor is set with --alpha or --half-life.
+	Use --stats count,min,max to add statistics of the window to each row,
+	and --quantiles 0.5,0.9,0.99 to add percentiles of the delivery time(p50, p90, p99).
+	With --checkpoint state.bin the run state is saved every --checkpoint-every events and when
+	interrupted, run it again with --resume to carry on where it stopped.
+	zcat events.json.gz | calculator_cli --input_file - --output - | jq`,
 	// SilenceUsage will stop displayinh usage(--help) when error from Execute.
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if window <= 0 {
-			return ErrInvalidWindow
+		// --window takes over the older --window_size, which is in minutes.
+		windowLength := time.Duration(windowDuration)
+		if windowLength == 0 {
+			if window <= 0 {
+				return ErrInvalidWindow
+			}
+			windowLength = time.Minute * time.Duration(window)
 		}
 
-		data, err := parseInputFile(inputFile)
+		queueFactory := engineQueueFactory
+		if follow {
+			queueFactory = longRunningQueueFactory
+		}
+		newQueue, err := queueFactory(engine)
 		if err != nil {
-			return ErrParseInputFile
+			return err
 		}
 
-		// result := FIFOSMA(data, window)
-		result := FIFOSMAMinified(data, window)
-		// result := BuffFIFOSMA(data, window)
-		return writeOutput(result)
+		opts := smaOptions{
+			Window:           windowLength,
+			Step:             time.Duration(step),
+			GroupBy:          groupBy,
+			Metric:           metricName,
+			Alpha:            alpha,
+			HalfLife:         halfLife,
+			Quantiles:        quantiles,
+			QuantileMode:     quantileMode,
+			QuantileAccuracy: quantileAccuracy,
+			Stats:            stats,
+			Gaps:             gaps,
+			AllowedLateness:  time.Duration(allowedLateness),
+			Late:             late,
+		}
+		// check options upfront, StreamSMA errors are reported as input errors.
+		if err := opts.validate(); err != nil {
+			return err
+		}
+		if _, err := metricFactory(opts); err != nil {
+			return err
+		}
+		parsedStats, err := parseStats(stats)
+		if err != nil {
+			return err
+		}
+		if err := validateQuantiles(quantiles); err != nil {
+			return err
+		}
+		if _, err := quantileWindowFactory(quantileMode, quantileAccuracy); err != nil {
+			return err
+		}
+		if err := validateSort(sortMode); err != nil {
+			return err
+		}
+		inOpts := inputOptions{Format: inputFormat, Delimiter: csvDelimiter, Columns: columns}
+		if err := validateInputFormat(inOpts); err != nil {
+			return err
+		}
+		dateLayout, err := validateOutputFormat(outputFormat, dateFormat)
+		if err != nil {
+			return err
+		}
+		columnOpts := opts
+		columnOpts.Stats = parsedStats
+		outColumns, err := outputColumns(columnOpts)
+		if err != nil {
+			return err
+		}
+		if err := validateOutputCompression(outputCompression); err != nil {
+			return err
+		}
+		errPolicy, deadLetters, err := parseOnError(onError)
+		if err != nil {
+			return err
+		}
+
+		// the filter is compiled once, before we read any event.
+		var fsrc *filterSource
+		if filterExpr != "" {
+			compiled, err := compileFilter(filterExpr)
+			if err != nil {
+				return err
+			}
+			fsrc = &filterSource{filter: compiled}
+		}
+
+		// --input_file can be several files, globs or directories, merged by timestamp.
+		files, err := expandInputs(inputFile)
+		if err != nil {
+			return err
+		}
+		if err := validateRunModes(files, deadLetters); err != nil {
+			return err
+		}
+
+		// a checkpoint from a previous run tells where to carry on from.
+		var key string
+		if checkpointPath != "" {
+			if key, err = runCheckpointKey(opts, files[0], inOpts); err != nil {
+				return err
+			}
+		}
+		cp, err := loadCheckpoint(checkpointPath, resume, key)
+		if err != nil {
+			return err
+		}
+
+		// followed files are read until we get SIGINT or SIGTERM, and so are checkpointed runs,
+		// which save their state before stopping.
+		ctx := cmd.Context()
+		if follow || checkpointPath != "" {
+			var stop context.CancelFunc
+			ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+		}
+
+		// invalid events fail the run, or are skipped and written to --on-error deadletter=<file>.
+		var deadCounted *countingWriter
+		var deadWriter io.Writer
+		if deadLetters != "" {
+			df, err := openOutput(deadLetters, checkpointPath != "", cp.DeadLetters, cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			defer df.Close()
+			deadCounted = &countingWriter{w: df, n: cp.DeadLetters}
+			deadWriter = deadCounted
+		}
+		policy := newErrorPolicy(errPolicy, deadWriter)
+		policy.skipped = cp.Skipped
+
+		// events are streamed from the input, we no longer load the whole file,
+		// and rows are written as soon as each minute is done.
+		in, err := openRunInput(ctx, files, inOpts, cmd.InOrStdin(), policy, cp)
+		if err != nil {
+			return err
+		}
+		defer in.closer.Close()
+		src := in.src
+		if fsrc != nil {
+			fsrc.src = src
+			fsrc.read, fsrc.rejected = cp.FilterRead, cp.FilterRejected
+			src = fsrc
+		}
+		// filtered events are sorted, no need to sort the ones we'll drop.
+		src, err = sortSource(src, sortMode, sortRun, sortDir)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrParseInputFile, err)
+		}
+		if c, ok := src.(io.Closer); ok {
+			defer c.Close()
+		}
+
+		out, err := openOutput(outputFile, checkpointPath != "", cp.Output, cmd.OutOrStdout())
+		if err != nil {
+			return err
+		}
+		out = compressOutput(out, outputCompression, follow)
+		// the close at the end checks the error, this one is for runs stopping before it.
+		defer func() {
+			if out != nil {
+				out.Close()
+			}
+		}()
+		counted := &countingWriter{w: out, n: cp.Output}
+
+		// late events are counted, and written to --late-events when given.
+		var lateOut io.Writer
+		if lateEvents != "" {
+			lf, err := openOutput(lateEvents, checkpointPath != "", cp.LateEvents, cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			defer lf.Close()
+			lateOut = lf
+		}
+		late := newLateOutput(lateOut, cp.LateCount, cp.LateEvents)
+		opts.OnLate = late.handle
+
+		rows := newRowWriter(counted, outputOptions{
+			Format:     outputFormat,
+			DateLayout: dateLayout,
+			Columns:    outColumns,
+			Flush:      follow,
+			Resumed:    cp.Output > 0,
+		})
+		s, err := newStreamerFor(newQueue, opts, rows)
+		if err != nil {
+			return err
+		}
+		s.restore(cp.State)
+
+		var save func() error
+		if checkpointPath != "" {
+			save = (&runCheckpoint{
+				path:        checkpointPath,
+				resumed:     cp,
+				input:       in.json,
+				followed:    in.followed,
+				filter:      fsrc,
+				policy:      policy,
+				deadLetters: deadCounted,
+				late:        late,
+				out:         out,
+				output:      counted,
+				rows:        rows,
+				streamer:    s,
+			}).save
+		}
+
+		stopped, err := runStreamer(ctx, src, s, checkpointEvery, save)
+		if err != nil {
+			if errors.Is(err, ErrWriteOutput) || errors.Is(err, ErrUnsortedInput) {
+				return err
+			}
+			return fmt.Errorf("%w: %w", ErrParseInputFile, err)
+		}
+		if stopped {
+			fmt.Fprintf(cmd.ErrOrStderr(), "checkpoint saved to %s, run again with --%s to carry on\n", checkpointPath, RESUME_FLAG)
+			if follow {
+				return nil
+			}
+			return ErrInterrupted
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := late.Flush(); err != nil {
+			return err
+		}
+		if err := policy.Flush(); err != nil {
+			return err
+		}
+		if late.count > 0 {
+			fmt.Fprintf(cmd.ErrOrStderr(), "%d late events left out\n", late.count)
+		}
+		policy.summary(cmd.ErrOrStderr())
+		if in.merged != nil {
+			in.merged.summary(cmd.ErrOrStderr())
+		}
+		if fsrc != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "filter rejected %d of %d events\n", fsrc.rejected, fsrc.read)
+		}
+		if outputFile != stdioName {
+			fmt.Fprintf(cmd.ErrOrStderr(), "check %s\n", outputFile)
+		}
+		err = out.Close()
+		out = nil
+		if err != nil {
+			return err
+		}
+		// the run is done, resuming it would write the last rows again.
+		if checkpointPath != "" {
+			if err := os.Remove(checkpointPath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
 	},
 }
 
+// validateRunModes checks --follow and --checkpoint go along with the other flags
+// and the input files.
+func validateRunModes(files []string, deadLetters string) error {
+	if follow && inputFile == stdioName {
+		return fmt.Errorf("%w: stdin is already read as it comes, use an input file", ErrInvalidFollow)
+	}
+	if follow && sortMode != "" && sortMode != sortNone {
+		return fmt.Errorf("%w: can't sort a file that keeps growing, use --allowed-lateness", ErrInvalidFollow)
+	}
+	// a table is aligned once all rows are in.
+	if follow && outputFormat == formatTable {
+		return fmt.Errorf("%w: table output is aligned once all rows are in, use csv or tsv", ErrInvalidFollow)
+	}
+	if checkpointPath != "" && outputFormat == formatTable {
+		return fmt.Errorf("%w: table output is aligned once all rows are in, use csv or tsv", ErrInvalidCheckpoint)
+	}
+	if checkpointPath != "" && outputCompression == compressionGzip {
+		return fmt.Errorf("%w: compressed output can't be carried on from where it stopped", ErrInvalidCheckpoint)
+	}
+	if len(files) > 1 && follow {
+		return fmt.Errorf("%w: only one file can be followed", ErrInvalidFollow)
+	}
+	if len(files) > 1 && checkpointPath != "" {
+		return fmt.Errorf("%w: several input files can't be carried on from where they stopped", ErrInvalidCheckpoint)
+	}
+	return validateCheckpoint(checkpointPath, checkpointEvery, resume, inputFile, outputFile, lateEvents, deadLetters, sortMode)
+}
+
+// runInput is the event source of a run, and what a checkpoint needs to know of it.
+type runInput struct {
+	src eventSource
+	// json is the source of a single json input, the only one that can be checkpointed.
+	json     *jsonSource
+	followed *followReader
+	merged   *filesSource
+	closer   io.Closer
+}
+
+// openRunInput opens the input files, several ones are merged by timestamp and a single one
+// carries on from the checkpoint, followed with --follow.
+func openRunInput(ctx context.Context, files []string, inOpts inputOptions, stdin io.Reader, policy *errorPolicy, cp *checkpoint) (*runInput, error) {
+	if len(files) > 1 {
+		merged, err := openFilesSource(files, inOpts, policy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrParseInputFile, err)
+		}
+		return &runInput{src: merged, merged: merged, closer: merged}, nil
+	}
+
+	in := &runInput{}
+	var f io.ReadCloser
+	var err error
+	if follow {
+		// compressed files aren't appended to line by line.
+		var compression string
+		compression, err = fileCompression(files[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrParseInputFile, err)
+		}
+		if compression != compressionNone {
+			return nil, fmt.Errorf("%w: %s compressed files can't be followed", ErrInvalidFollow, compression)
+		}
+		in.followed, err = openFollowAt(ctx, files[0], cp.Input)
+		f = in.followed
+	} else {
+		f, err = openInputAt(files[0], stdin, cp.Input)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrParseInputFile, err)
+	}
+	in.closer = f
+
+	// a resumed input is json, only json inputs can be checkpointed.
+	r := bufio.NewReader(f)
+	format := inOpts.Format
+	if format == "" && cp.Input == 0 {
+		format, err = detectInputFormat(files[0], r)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%w: %w", ErrParseInputFile, err)
+		}
+	} else if format == "" {
+		format = formatJSON
+	}
+	if checkpointPath != "" && !isJSONFormat(format) {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s input can't be resumed, only json", ErrInvalidCheckpoint, format)
+	}
+	inOpts.Format = format
+
+	if isJSONFormat(format) {
+		in.json, err = resumeJSONSource(r, cp.Input, cp.InputLines, cp.InArray)
+		in.src = in.json
+	} else {
+		in.src, err = newCSVSource(r, inOpts)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: %w", ErrParseInputFile, err)
+	}
+	in.src = &errorSource{src: in.src, file: files[0], policy: policy}
+	return in, nil
+}
+
+// runCheckpointKey returns the checkpoint key of a run of input, with the flags it's given.
+func runCheckpointKey(opts smaOptions, input string, inOpts inputOptions) (string, error) {
+	abs, err := filepath.Abs(input)
+	if err != nil {
+		return "", err
+	}
+	return checkpointKey(opts, filterExpr, checkpointFiles{
+		Input:        abs,
+		InputOptions: inOpts,
+		OutputFormat: outputFormat,
+		DateFormat:   dateFormat,
+		OnError:      onError,
+	}), nil
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() error {
@@ -57,8 +509,40 @@ func Execute() error {
 func init() {
 	// define your flags and configuration settings.
 	// TODO: we'r defaulting/expecting input json to be at root level
-	rootCmd.Flags().StringVar(&inputFile, "input_file", "../events.json", "The input file with recored events")
+	rootCmd.Flags().StringVar(&inputFile, "input_file", "../events.json", "The input file with recored events, use - for stdin")
+	rootCmd.Flags().StringVar(&outputFile, OUTPUT_FLAG, "./result.txt", "The output file for the sma results, use - for stdout")
+	rootCmd.Flags().StringVar(&engine, ENGINE_FLAG, "fifo", "The sma engine, one of: "+engineNames())
+	rootCmd.Flags().StringSliceVar(&groupBy, GROUP_BY_FLAG, nil, "Event fields to calculate a separate sma for, e.g. client_name or source_language,target_language")
+	rootCmd.Flags().StringVar(&filterExpr, FILTER_FLAG, "", `Only consider events matching the expression, e.g. 'nr_words > 50 && client_name in ("airliberty")'`)
+	rootCmd.Flags().StringVar(&metricName, METRIC_FLAG, "sma", "The moving average to calculate, one of: "+metricNames())
+	rootCmd.Flags().Float64Var(&alpha, ALPHA_FLAG, 0, "The ema smoothing factor in (0, 1], defaults to 2/(N+1) where N is the window in steps")
+	rootCmd.Flags().DurationVar(&halfLife, HALF_LIFE_FLAG, 0, "The ema half-life, e.g. 5m, an alternative to --alpha")
+
+	rootCmd.Flags().StringSliceVar(&stats, STATS_FLAG, nil, "Statistics of the window to add to each row, any of: avg, "+strings.Join(statNames, ", "))
+	rootCmd.Flags().Float64SliceVar(&quantiles, QUANTILES_FLAG, nil, "Quantiles of the delivery time to add to each row, e.g. 0.5,0.9,0.99")
+	rootCmd.Flags().StringVar(&quantileMode, QUANTILE_MODE_FLAG, "exact", "How quantiles are calculated, exact or approx(DDSketch, memory doesn't grow with the window)")
+	rootCmd.Flags().Float64Var(&quantileAccuracy, QUANTILE_ACCURACY_FLAG, 0.01, "The relative accuracy of approx quantiles")
 	rootCmd.Flags().Int32Var(&window, "window_size", 10, "The time window considered in the sma calculation")
+	rootCmd.Flags().Var(&windowDuration, WINDOW_FLAG, "The time window as a duration, e.g. 15m, 2h or 1d, overrides --window_size")
+	rootCmd.Flags().Var(&step, STEP_FLAG, "The time between output rows, e.g. 10s, 1m, 5m or 1h")
+	rootCmd.Flags().StringVar(&sortMode, SORT_FLAG, sortNone, "How to sort unsorted input by timestamp: none(input must be sorted), memory or external(on-disk merge sort)")
+	rootCmd.Flags().IntVar(&sortRun, SORT_RUN_FLAG, defaultSortRun, "How many events --sort external sorts in memory at once")
+	rootCmd.Flags().StringVar(&sortDir, SORT_DIR_FLAG, "", "Where --sort external writes its temp files, the system temp dir by default")
+	rootCmd.Flags().Var(&allowedLateness, ALLOWED_LATENESS_FLAG, "How far behind the most recent event an event can arrive and still be in its rows, e.g. 30s")
+	rootCmd.Flags().StringVar(&late, LATE_FLAG, lateFail, "What to do with events arriving later than --allowed-lateness: fail, drop or correct(write correction rows)")
+	rootCmd.Flags().StringVar(&onError, ON_ERROR_FLAG, onErrorFail, "What to do with invalid events: fail, skip or deadletter=<file>(skip them and write them to the file, as json lines)")
+	rootCmd.Flags().StringVar(&lateEvents, LATE_EVENTS_FLAG, "", "A file to write events left out for being late, as json lines")
+	rootCmd.Flags().StringVar(&inputFormat, INPUT_FORMAT_FLAG, "", "The input format, one of: json, ndjson, csv, tsv, detected from the file extension or content by default")
+	rootCmd.Flags().StringVar(&csvDelimiter, DELIMITER_FLAG, "", `The csv column delimiter, "," for csv and "\t" for tsv by default`)
+	rootCmd.Flags().StringToStringVar(&columns, COLUMNS_FLAG, nil, "Maps event fields to csv columns with other names, e.g. duration=time_ms,timestamp=created_at")
+	rootCmd.Flags().StringVar(&outputFormat, OUTPUT_FORMAT_FLAG, formatNDJSON, "The output format, one of: ndjson, json, csv, tsv, table")
+	rootCmd.Flags().StringVar(&outputCompression, OUTPUT_COMPRESSION_FLAG, compressionNone, "Compress the output, none or gzip")
+	rootCmd.Flags().StringVar(&dateFormat, DATE_FORMAT_FLAG, "", "The date layout, a go time layout, e.g. 2006-01-02T15:04, or one of: default, date, rfc3339, rfc3339nano")
+	rootCmd.Flags().StringVar(&checkpointPath, CHECKPOINT_FLAG, "", "A file to save the run state to, every --checkpoint-every events and when interrupted")
+	rootCmd.Flags().IntVar(&checkpointEvery, CHECKPOINT_EVERY_FLAG, 100000, "How many events to read between checkpoints")
+	rootCmd.Flags().BoolVar(&resume, RESUME_FLAG, false, "Carry on from the --checkpoint of an interrupted run, if there's one")
+	rootCmd.Flags().BoolVar(&follow, FOLLOW_FLAG, false, "Keep reading the input file as new lines are written to it, until SIGINT or SIGTERM")
+	rootCmd.Flags().StringVar(&gaps, GAPS_FLAG, gapsZero, "What to write when the window is empty: zero, carry, skip or null(a single null row per gap)")
 	// TODO: define if we want them to be required of if we can default.
 	// default is a good option!
 }
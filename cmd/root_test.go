@@ -2,10 +2,13 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 	"time"
 
@@ -47,6 +50,22 @@ func TestRoot(t *testing.T) {
 			args:    []string{"--window_size=-1"},
 			wantErr: ErrInvalidWindow,
 		},
+		{
+			name:    "when invalid step should error",
+			args:    []string{"--window_size=10", "--step=0s"},
+			wantErr: ErrInvalidStep,
+			cleanup: func(t *testing.T) {
+				require.NoError(t, rootCmd.Flags().Set(STEP_FLAG, "1m"))
+			},
+		},
+		{
+			name:    "when unknown engine should error",
+			args:    []string{"--window_size=10", "--engine=unknown"},
+			wantErr: ErrUnknownEngine,
+			cleanup: func(t *testing.T) {
+				require.NoError(t, rootCmd.Flags().Set(ENGINE_FLAG, "fifo"))
+			},
+		},
 	}
 
 	for _, tc := range tcs {
@@ -113,6 +132,31 @@ func TestRootRegression(t *testing.T) {
 	}
 }
 
+func TestRootStdio(t *testing.T) {
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	var stdout bytes.Buffer
+
+	rootCmd.SetIn(bytes.NewReader(input))
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetArgs([]string{"--input_file=-", "--output=-", "--window_size=10"})
+	defer func() {
+		rootCmd.SetIn(nil)
+		rootCmd.SetOut(nil)
+		// flags outlive Execute, put defaults back for other tests.
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+	}()
+
+	err = rootCmd.Execute()
+	require.NoError(t, err)
+
+	want, err := os.ReadFile("./testResult.txt")
+	require.NoError(t, err)
+	require.Equal(t, string(want), stdout.String())
+	require.NoFileExists(t, "./result.txt")
+}
+
 func TestRootWithHeavyLoad(t *testing.T) {
 	// we will skip this from the "make test" call
 	// since it's used only insights on improvements.
@@ -120,10 +164,9 @@ func TestRootWithHeavyLoad(t *testing.T) {
 		t.Skip("skipping testing in short mode")
 	}
 	tcs := []struct {
-		name    string
-		args    []string
-		setup   func()
-		cleanup func(t *testing.T)
+		name  string
+		args  func(dir string) []string
+		setup func(dir string)
 	}{
 		// 100000 entries
 		// executed in: ~84.409s
@@ -131,18 +174,17 @@ func TestRootWithHeavyLoad(t *testing.T) {
 		// executed in:54.73s
 		// from now we need to decide where to benchmark!
 		{
-			// will use created heavy-load.json file located at project root level.
-			name: "when input file has 100 entries should successfuly process",
-			args: []string{"--input_file=./heavy-load.json", "--window_size=5"},
-			setup: func() {
-				generateSampelFile("./heavy-load.json", _1M)
+			// files go to a temp dir, removed even when the test fails.
+			name: "when input file has 1M entries should successfuly process",
+			args: func(dir string) []string {
+				return []string{
+					"--input_file=" + filepath.Join(dir, "heavy-load.json"),
+					"--output=" + filepath.Join(dir, "result.txt"),
+					"--window_size=5",
+				}
 			},
-			cleanup: func(t *testing.T) {
-				err := os.Remove("./heavy-load.json")
-				require.NoError(t, err)
-
-				err = os.Remove("./result.txt")
-				require.NoError(t, err)
+			setup: func(dir string) {
+				generateSampelFile(filepath.Join(dir, "heavy-load.json"), _1M)
 			},
 		},
 	}
@@ -150,15 +192,21 @@ func TestRootWithHeavyLoad(t *testing.T) {
 	for _, tc := range tcs {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Cleanup(func() {
+				// flags outlive Execute, put defaults back for other tests.
+				require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+				require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+			})
 			// create sample data
-			tc.setup()
+			tc.setup(dir)
 			// Set the flags before executing the command.
-			rootCmd.SetArgs(tc.args)
+			rootCmd.SetArgs(tc.args(dir))
 
 			// Execute the command.
 			err := rootCmd.Execute()
 			require.NoError(t, err)
-			tc.cleanup(t)
+			require.FileExists(t, filepath.Join(dir, "result.txt"))
 		})
 	}
 }
@@ -223,6 +271,8 @@ func generateSampelFile(filename string, numEntries int) {
 		// increase event timestamp over time.
 		starTime = starTime.Add(time.Minute)
 	}
+	// input must be sorted, the timestamp layout sorts like the time it stands for.
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp < events[j].Timestamp })
 
 	file, err := os.Create(filename)
 	if err != nil {
@@ -0,0 +1,622 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	ADDR_FLAG          = "addr"
+	MAX_EVENTS_FLAG    = "max-events"
+	RETENTION_FLAG     = "retention"
+	MAX_BODY_FLAG      = "max-body"
+	STREAM_BUFFER_FLAG = "stream-buffer"
+	SMA_HISTORY_FLAG   = "sma-history"
+)
+
+var (
+	serveAddr   string
+	serveEngine string
+	maxEvents   int
+	retention   = durationValue(24 * time.Hour)
+	maxBody     int64
+	// the live stream is calculated as events arrive, so its settings are fixed at start.
+	streamWindow    = durationValue(10 * time.Minute)
+	streamStep      = durationValue(time.Minute)
+	streamGroupBy   []string
+	streamStats     []string
+	streamQuantiles []float64
+	streamLateness  durationValue
+	streamBuffer    int
+	smaHistory      int
+	serveCheckpoint string
+	serveResume     bool
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// shutdownTimeout is how long in flight requests get to finish once we are asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+// serveCmd runs the calculator as an http server, events are pushed to it instead of read from a file.
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serves an http api to push events and query their sma",
+	Long: `Serve keeps the most recent events pushed to POST /events, as a json object,
+	a json array or one event per line, and calculates the sma of them on GET /sma, e.g.
+	curl -X POST --data-binary @events.json localhost:8080/events
+	curl 'localhost:8080/sma?window=10&group_by=client_name&last=5'
+	GET /sma takes window(minutes or a duration, e.g. 15m), step, group_by, metric, stats
+	and quantiles like the calculator flags, and last, how many of the most recent rows to get.
+	Memory is bounded by --max-events and --retention, older events are evicted.
+	GET /stream pushes each row as server-sent events once its minute closes, with the sma of
+	--window grouped by --group-by, query values filter groups, e.g. /stream?client_name=airliberty.
+	A group is dropped once its window is empty, after a last row, and comes back with its next event.
+	GET /sma asked for the --window, --step, --group-by, --stats and --quantiles of GET /stream is
+	answered from its rows of the last --sma-history steps, other queries are calculated from the
+	events kept, and also have the rows of minutes still open.
+	GET /metrics has the most recent row of each group, --stats and --quantiles included, and the
+	window queue sizes in the prometheus text format, to be scraped.
+	The server stops gracefully on SIGINT or SIGTERM, with --checkpoint state.bin the events kept
+	and the GET /stream state are saved then, and loaded again on start with --resume.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		newQueue, err := longRunningQueueFactory(serveEngine)
+		if err != nil {
+			return err
+		}
+		srv := newServer(newEventStore(maxEvents, time.Duration(retention)), newQueue, maxBody)
+		err = srv.startLive(smaOptions{
+			Window:          time.Duration(streamWindow),
+			Step:            time.Duration(streamStep),
+			GroupBy:         streamGroupBy,
+			Stats:           streamStats,
+			Quantiles:       streamQuantiles,
+			AllowedLateness: time.Duration(streamLateness),
+			// rows already pushed can't be taken back, late events are only in GET /sma.
+			Late: lateDrop,
+		}, streamBuffer, smaHistory)
+		if err != nil {
+			return err
+		}
+		if err := validateCheckpoint(serveCheckpoint, 0, serveResume, "", "", "", "", ""); err != nil {
+			return err
+		}
+		srv.checkpoint = serveCheckpoint
+		if serveResume {
+			if err := srv.resume(); err != nil {
+				return err
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		l, err := net.Listen("tcp", serveAddr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "listening on %s\n", l.Addr())
+		return srv.serve(ctx, l)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveAddr, ADDR_FLAG, ":8080", "The address to listen on")
+	serveCmd.Flags().StringVar(&serveEngine, ENGINE_FLAG, "buffifo", "The sma engine, one of: "+engineNames())
+	serveCmd.Flags().IntVar(&maxEvents, MAX_EVENTS_FLAG, 1000000, "How many events to keep at most, the oldest ones are evicted")
+	serveCmd.Flags().Var(&retention, RETENTION_FLAG, "How long to keep events, behind the most recent one, it's the largest window that can be asked")
+	serveCmd.Flags().Int64Var(&maxBody, MAX_BODY_FLAG, 10<<20, "The largest POST /events body in bytes")
+	serveCmd.Flags().Var(&streamWindow, WINDOW_FLAG, "The time window of GET /stream rows, e.g. 10m")
+	serveCmd.Flags().Var(&streamStep, STEP_FLAG, "The time between GET /stream rows")
+	serveCmd.Flags().StringSliceVar(&streamGroupBy, GROUP_BY_FLAG, []string{"client_name", "source_language", "target_language"}, "Event fields to group GET /stream rows and GET /metrics series by")
+	serveCmd.Flags().StringSliceVar(&streamStats, STATS_FLAG, []string{"count"}, "Statistics of the window to add to GET /stream rows and GET /metrics, any of: avg, "+strings.Join(statNames, ", "))
+	serveCmd.Flags().Float64SliceVar(&streamQuantiles, QUANTILES_FLAG, []float64{0.5, 0.9, 0.99}, "Quantiles of the delivery time to add to GET /stream rows and GET /metrics")
+	serveCmd.Flags().Var(&streamLateness, ALLOWED_LATENESS_FLAG, "How far behind the most recent event an event can arrive and still be in GET /stream rows")
+	serveCmd.Flags().StringVar(&serveCheckpoint, CHECKPOINT_FLAG, "", "A file to save the events kept and the GET /stream state to when stopping")
+	serveCmd.Flags().BoolVar(&serveResume, RESUME_FLAG, false, "Load the --checkpoint saved when the server stopped, if there's one")
+	serveCmd.Flags().IntVar(&streamBuffer, STREAM_BUFFER_FLAG, 256, "How many rows a slow GET /stream client can fall behind before rows are dropped for it")
+	serveCmd.Flags().IntVar(&smaHistory, SMA_HISTORY_FLAG, 60, "How many of the most recent GET /stream buckets are kept to answer GET /sma without calculating them again")
+}
+
+// server is the http api of the serve command.
+type server struct {
+	store    *eventStore
+	newQueue func() windowQueue
+	maxBody  int64
+	// live calculates rows as events arrive and hands them to hub and latest, liveMu guards it.
+	liveMu    sync.Mutex
+	live      *streamer
+	hub       *hub
+	latest    *latestRows
+	recent    *recentRows
+	quantiles []float64
+	// checkpoint is where the state is saved when stopping, liveKey tells the live options it's for.
+	checkpoint string
+	liveKey    string
+}
+
+// newServer creates a server keeping events in store.
+func newServer(store *eventStore, newQueue func() windowQueue, maxBody int64) *server {
+	return &server{store: store, newQueue: newQueue, maxBody: maxBody}
+}
+
+// startLive starts calculating rows for GET /stream with opts, as events arrive.
+// The rows of the last history buckets are kept for GET /sma.
+func (s *server) startLive(opts smaOptions, buffer, history int) error {
+	h, latest := newHub(buffer), newLatestRows()
+	// groups come and go with the feed, the ones with an empty window are let go.
+	opts.DropDrained, opts.OnDrop = true, latest.drop
+	recent := newRecentRows(opts.withDefaults().Step, history)
+	live, err := newStreamerFor(s.newQueue, opts, teeSink{h, latest, recent})
+	if err != nil {
+		return err
+	}
+	s.live, s.hub, s.latest, s.recent, s.quantiles = live, h, latest, recent, opts.Quantiles
+	s.liveKey = checkpointKey(opts, "", checkpointFiles{})
+	return nil
+}
+
+// recentRows is a sink keeping the live rows of the most recent buckets, so GET /sma
+// asked for the live options is answered without calculating them again.
+// Rows come in bucket order, late events are dropped by the live stream.
+type recentRows struct {
+	mu      sync.Mutex
+	step    time.Duration
+	buckets int
+	rows    []output
+	// first is the first bucket seen, rows before it were never kept, e.g. before a restart.
+	first time.Time
+}
+
+// newRecentRows creates a recentRows keeping the rows of the last buckets.
+func newRecentRows(step time.Duration, buckets int) *recentRows {
+	return &recentRows{step: step, buckets: buckets}
+}
+
+// Emit keeps the row and lets go of the ones of buckets no longer among the last ones.
+func (r *recentRows) Emit(row output) error {
+	if r.buckets <= 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.first.IsZero() {
+		r.first = row.Date
+	}
+	r.rows = append(r.rows, row)
+	from := row.Date.Add(-time.Duration(r.buckets-1) * r.step)
+	if i := sort.Search(len(r.rows), func(i int) bool { return !r.rows[i].Date.Before(from) }); i > 0 {
+		r.rows = append(r.rows[:0], r.rows[i:]...)
+	}
+	return nil
+}
+
+// last returns the rows of the last n buckets, it's false when they weren't all kept.
+func (r *recentRows) last(n int) ([]output, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n > r.buckets || len(r.rows) == 0 {
+		return nil, false
+	}
+	from := r.rows[len(r.rows)-1].Date.Add(-time.Duration(n-1) * r.step)
+	if from.Before(r.first) {
+		return nil, false
+	}
+	i := sort.Search(len(r.rows), func(i int) bool { return !r.rows[i].Date.Before(from) })
+	return append([]output(nil), r.rows[i:]...), true
+}
+
+// liveRows returns the rows of the last buckets GET /sma asked for from the live stream,
+// it's false when the query has other options or the rows weren't kept.
+func (s *server) liveRows(opts smaOptions, last int) ([]output, bool) {
+	s.liveMu.Lock()
+	live := s.live
+	s.liveMu.Unlock()
+	if live == nil || s.recent == nil {
+		return nil, false
+	}
+	stats, err := parseStats(opts.Stats)
+	if err != nil {
+		return nil, false
+	}
+	opts = opts.withDefaults()
+	opts.Stats = stats
+	if !sameRows(opts, live.opts) {
+		return nil, false
+	}
+	return s.recent.last(last)
+}
+
+// sameRows tells if a and b give the same rows, how late events and drained groups
+// are handled left out.
+func sameRows(a, b smaOptions) bool {
+	return reflect.DeepEqual(rowOptions(a), rowOptions(b))
+}
+
+// rowOptions returns the options of o rows depend on, with defaults and empty lists as nil.
+func rowOptions(o smaOptions) smaOptions {
+	r := smaOptions{
+		Window:           o.Window,
+		Step:             o.Step,
+		Metric:           o.Metric,
+		Alpha:            o.Alpha,
+		HalfLife:         o.HalfLife,
+		QuantileMode:     o.QuantileMode,
+		QuantileAccuracy: o.QuantileAccuracy,
+		Gaps:             o.Gaps,
+	}
+	if r.Metric == "" {
+		r.Metric = "sma"
+	}
+	if r.Gaps == "" {
+		r.Gaps = gapsZero
+	}
+	if len(o.GroupBy) > 0 {
+		r.GroupBy = o.GroupBy
+	}
+	if len(o.Quantiles) > 0 {
+		r.Quantiles = o.Quantiles
+	}
+	if len(o.Stats) > 0 {
+		r.Stats = o.Stats
+	}
+	return r
+}
+
+// resume loads the events and live state saved to the checkpoint when the server last stopped.
+func (s *server) resume() error {
+	cp, err := readCheckpoint(s.checkpoint, s.liveKey)
+	if err != nil || cp == nil {
+		return err
+	}
+	s.store.add(cp.Events...)
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	s.live.restore(cp.State)
+	return nil
+}
+
+// stopLive writes the rows left to subscribers and closes them. With a checkpoint the
+// state is saved instead, rows of open minutes are written once the server is back.
+func (s *server) stopLive() error {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	if s.live == nil {
+		return nil
+	}
+	var err error
+	if s.checkpoint != "" {
+		err = writeCheckpoint(s.checkpoint, &checkpoint{Key: s.liveKey, Events: s.store.snapshot(), State: s.live.state()})
+	} else {
+		err = s.live.Close()
+	}
+	s.hub.close()
+	s.live = nil
+	return err
+}
+
+// routes returns the server handler.
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", s.handleEvents)
+	mux.HandleFunc("/sma", s.handleSMA)
+	mux.HandleFunc("/stream", s.handleStream)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return mux
+}
+
+// serve serves requests on l until ctx is done, then waits for in flight requests to finish.
+func (s *server) serve(ctx context.Context, l net.Listener) error {
+	hs := &http.Server{Handler: s.routes(), ReadHeaderTimeout: 10 * time.Second}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- hs.Serve(l)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	// streams never end by themselves, closing them lets Shutdown wait only for requests.
+	if err := s.stopLive(); err != nil {
+		return err
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return hs.Shutdown(shutdownCtx)
+}
+
+// handleEvents stores the events of the request body, all of them or none when any is invalid.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// a single object is ndjson with one line, jsonSource reads all three.
+	src := newJSONSource(http.MaxBytesReader(w, r.Body, s.maxBody))
+	var events []event
+	for {
+		e, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events = append(events, e)
+	}
+
+	// events are stored once the live stream took them, so a retry after an error
+	// doesn't count them twice.
+	if err := s.pushLive(events); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.store.add(events...)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"accepted": len(events)})
+}
+
+// pushLive pushes events to the live stream, if there's one.
+func (s *server) pushLive(events []event) error {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	if s.live == nil {
+		return nil
+	}
+	for _, e := range events {
+		if err := s.live.Push(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleStream pushes rows to the client as server-sent events once their minute closes.
+// Query values filter groups by --group-by fields, e.g. ?client_name=airliberty&client_name=taxi-eats.
+// When the client falls too far behind rows are dropped for it, and a dropped event
+// with how many is sent before the next row.
+func (s *server) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok || s.hub == nil {
+		http.Error(w, "streaming is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	// a name that isn't a --group-by field would match no row, and the stream stay silent.
+	s.liveMu.Lock()
+	groupBy := s.live.opts.GroupBy
+	s.liveMu.Unlock()
+	for name := range r.URL.Query() {
+		if !containsString(groupBy, name) {
+			err := fmt.Errorf("%w: %q isn't a --group-by field, filter by any of: %s", ErrInvalidQuery, name, strings.Join(groupBy, ", "))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub := s.hub.subscribe(r.URL.Query())
+	defer s.hub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case row, ok := <-sub.rows:
+			if !ok {
+				return
+			}
+			if dropped := sub.dropped.Swap(0); dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped)
+			}
+			bs, err := json.Marshal(row)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", bs)
+			flusher.Flush()
+		}
+	}
+}
+
+// handleMetrics writes the current window of every group in the prometheus text format.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var rows []output
+	var emitted int64
+	if s.latest != nil {
+		rows, emitted = s.latest.sorted(), s.latest.count()
+	}
+	subscribers := 0
+	if s.hub != nil {
+		subscribers = s.hub.len()
+	}
+	stored, evicted := s.store.counts()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, rows, s.quantiles, s.queueSizes(), []promMetric{
+		{name: "stored_events", typ: "gauge", help: "How many events are kept for GET /sma.", value: float64(stored)},
+		{name: "evicted_events_total", typ: "counter", help: "How many events were evicted to keep memory bounded.", value: float64(evicted)},
+		{name: "rows_total", typ: "counter", help: "How many rows were calculated as events arrived, corrections included.", value: float64(emitted)},
+		{name: "stream_subscribers", typ: "gauge", help: "How many clients are subscribed to GET /stream.", value: float64(subscribers)},
+	})
+}
+
+// queueSizes returns the window queue size of every live group.
+func (s *server) queueSizes() []queueSize {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	if s.live == nil {
+		return nil
+	}
+	sizes := make([]queueSize, 0, len(s.live.groups.sorted))
+	for _, g := range s.live.groups.sorted {
+		size := queueSize{labels: g.labels, capacity: -1}
+		if q, ok := g.queue.(interface{ Len() int }); ok {
+			size.length = q.Len()
+		}
+		if q, ok := g.queue.(interface{ Cap() int }); ok {
+			size.capacity = q.Cap()
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
+
+// handleSMA calculates the sma of the stored events and writes the most recent rows,
+// as json lines like the calculator output.
+func (s *server) handleSMA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts, last, err := parseSMAQuery(r.URL.Query())
+	if err == nil && opts.Window > s.store.retention {
+		err = fmt.Errorf("%w: window is larger than the %s retention", ErrInvalidQuery, s.store.retention)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the live stream already has the rows of its own options.
+	rows, ok := s.liveRows(opts, last)
+	if !ok {
+		// groups are dropped like in the live stream, so older events don't change the rows.
+		opts.DropDrained = true
+		events := s.store.since(smaFrom(opts, last, s.store.latest()))
+		err = StreamSMA(&sliceSource{events: events}, s.newQueue, opts, funcSink(func(row output) error {
+			rows = append(rows, row)
+			return nil
+		}))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// rows are ordered by bucket, keep the ones of the last buckets.
+		if len(rows) > 0 {
+			from := rows[len(rows)-1].Date.Add(-time.Duration(last-1) * opts.withDefaults().Step)
+			rows = rows[sort.Search(len(rows), func(i int) bool { return !rows[i].Date.Before(from) }):]
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	out := newWriterSink(w)
+	for _, row := range rows {
+		if err := out.Emit(row); err != nil {
+			return
+		}
+	}
+	out.Flush()
+}
+
+// smaFrom returns the oldest event timestamp the rows of the last buckets need, latest being
+// the most recent event. The last row is the bucket after the one of latest, every row needs
+// the events of its window, and one step more tells the groups that drain in the first row.
+// ema needs every event, so it's zero with it.
+func smaFrom(opts smaOptions, last int, latest time.Time) time.Time {
+	if opts.Metric == "ema" || latest.IsZero() {
+		return time.Time{}
+	}
+	step := opts.withDefaults().Step
+	return bucket(latest, step).Add(-time.Duration(last-1)*step - opts.Window)
+}
+
+// parseSMAQuery reads the GET /sma query into smaOptions and how many buckets to return.
+func parseSMAQuery(q url.Values) (smaOptions, int, error) {
+	opts := smaOptions{
+		Window:  10 * time.Minute,
+		GroupBy: splitList(q.Get("group_by")),
+		Metric:  q.Get("metric"),
+		Stats:   splitList(q.Get("stats")),
+	}
+
+	// window is in minutes like --window_size, or a duration like --window.
+	if v := q.Get("window"); v != "" {
+		if minutes, err := strconv.Atoi(v); err == nil {
+			opts.Window = time.Duration(minutes) * time.Minute
+		} else if opts.Window, err = parseDuration(v); err != nil {
+			return opts, 0, fmt.Errorf("%w: window: %v", ErrInvalidQuery, err)
+		}
+	}
+	if v := q.Get("step"); v != "" {
+		var err error
+		if opts.Step, err = parseDuration(v); err != nil {
+			return opts, 0, fmt.Errorf("%w: step: %v", ErrInvalidQuery, err)
+		}
+	}
+	for _, v := range splitList(q.Get("quantiles")) {
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return opts, 0, fmt.Errorf("%w: quantiles: %v", ErrInvalidQuery, err)
+		}
+		opts.Quantiles = append(opts.Quantiles, p)
+	}
+
+	last := 1
+	if v := q.Get("last"); v != "" {
+		var err error
+		if last, err = strconv.Atoi(v); err != nil || last <= 0 {
+			return opts, 0, fmt.Errorf("%w: last must be a positive integer", ErrInvalidQuery)
+		}
+	}
+	return opts, last, opts.withDefaults().validate()
+}
+
+// splitList splits a comma separated query value, empty values are left out.
+func splitList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
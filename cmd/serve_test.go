@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer starts a server keeping up to max events.
+func newTestServer(t *testing.T, max int) (*server, *httptest.Server) {
+	srv := newServer(newEventStore(max, 24*time.Hour), engines["buffifo"], 1<<20)
+	ts := httptest.NewServer(srv.routes())
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+// doRequest sends a request and returns the response status and body.
+func doRequest(t *testing.T, method, url, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(bs)
+}
+
+func TestServer(t *testing.T) {
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	want, err := os.ReadFile("./testResult.txt")
+	require.NoError(t, err)
+	wantRows := strings.SplitAfter(string(want), "\n")
+
+	first := `{"timestamp":"2018-12-26 18:11:08.509654","client_name":"airliberty","duration":20}`
+	ndjson := `{"timestamp":"2018-12-26 18:15:19.903159","client_name":"airliberty","duration":31}
+{"timestamp":"2018-12-26 18:23:19.903159","client_name":"taxi-eats","duration":54}
+`
+
+	tcs := []struct {
+		name       string
+		post       []string
+		query      string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "when posting an array should calculate sma of the last minute",
+			post:       []string{string(input)},
+			query:      "window=10",
+			wantStatus: http.StatusOK,
+			wantBody:   wantRows[13],
+		},
+		{
+			name:       "when posting an object and ndjson should calculate the same sma",
+			post:       []string{first, ndjson},
+			query:      "window=10&last=14",
+			wantStatus: http.StatusOK,
+			wantBody:   string(want),
+		},
+		{
+			name:       "when events come out of order should calculate the same sma",
+			post:       []string{ndjson, first},
+			query:      "window=10m&last=2",
+			wantStatus: http.StatusOK,
+			wantBody:   wantRows[12] + wantRows[13],
+		},
+		{
+			name:       "when grouping should calculate sma of each group",
+			post:       []string{string(input)},
+			query:      "group_by=client_name&window=10",
+			wantStatus: http.StatusOK,
+			wantBody: `{"date":"2018-12-26 18:24:00","client_name":"airliberty","average_delivery_time":31}
+{"date":"2018-12-26 18:24:00","client_name":"taxi-eats","average_delivery_time":54}
+`,
+		},
+		{
+			name:       "when window is invalid should be a bad request",
+			post:       []string{string(input)},
+			query:      "window=-1",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   ErrInvalidWindow.Error() + "\n",
+		},
+		{
+			name:       "when window is larger than retention should be a bad request",
+			query:      "window=2d",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid query: window is larger than the 24h0m0s retention\n",
+		},
+		{
+			name:       "when last is invalid should be a bad request",
+			query:      "last=0",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid query: last must be a positive integer\n",
+		},
+		{
+			name:       "when no events should be empty",
+			query:      "window=10",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, ts := newTestServer(t, 0)
+			for _, body := range tc.post {
+				status, _ := doRequest(t, http.MethodPost, ts.URL+"/events", body)
+				require.Equal(t, http.StatusAccepted, status)
+			}
+
+			status, body := doRequest(t, http.MethodGet, ts.URL+"/sma?"+tc.query, "")
+			require.Equal(t, tc.wantStatus, status)
+			require.Equal(t, tc.wantBody, body)
+		})
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	srv, ts := newTestServer(t, 2)
+
+	status, body := doRequest(t, http.MethodPost, ts.URL+"/events", `[{"timestamp":"2018-12-26 18:11:08.509654","duration":20},{"duration":1}]`)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Contains(t, body, ErrInvalidEvent.Error())
+	require.Equal(t, 0, srv.store.len(), "invalid requests store nothing")
+
+	status, _ = doRequest(t, http.MethodGet, ts.URL+"/events", "")
+	require.Equal(t, http.StatusMethodNotAllowed, status)
+
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	status, body = doRequest(t, http.MethodPost, ts.URL+"/events", string(input))
+	require.Equal(t, http.StatusAccepted, status)
+	require.Equal(t, `{"accepted":3}`+"\n", body)
+	require.Equal(t, 2, srv.store.len(), "store keeps max events")
+	require.Equal(t, 1, srv.store.evicted)
+
+	// events the live stream doesn't take aren't stored, a retry doesn't count them twice.
+	srv, ts = newTestServer(t, 0)
+	require.NoError(t, srv.startLive(smaOptions{Window: 10 * time.Minute, Late: lateFail}, 16, 60))
+	status, _ = doRequest(t, http.MethodPost, ts.URL+"/events", `{"timestamp":"2018-12-26 18:23:19.903159","duration":54}`)
+	require.Equal(t, http.StatusAccepted, status)
+	status, _ = doRequest(t, http.MethodPost, ts.URL+"/events", `{"timestamp":"2018-12-26 18:11:08.509654","duration":20}`)
+	require.Equal(t, http.StatusInternalServerError, status)
+	require.Equal(t, 1, srv.store.len())
+}
+
+func TestEventStoreRetention(t *testing.T) {
+	store := newEventStore(0, 10*time.Minute)
+	base := time.Date(2018, 12, 26, 18, 11, 0, 0, time.UTC)
+	for _, m := range []int{0, 5, 3, 12, 16} {
+		store.add(event{Timestamp: customTime{base.Add(time.Duration(m) * time.Minute)}, Duration: m})
+	}
+
+	var got []int
+	for _, e := range store.snapshot() {
+		got = append(got, e.Duration)
+	}
+	require.Equal(t, []int{12, 16}, got)
+	require.Equal(t, 3, store.evicted)
+
+	// an event an hour later evicts all of them, the survivor is copied out of the old array.
+	store.add(event{Timestamp: customTime{base.Add(time.Hour)}, Duration: 60})
+	require.Equal(t, 1, store.len())
+	require.Equal(t, 1, cap(store.events))
+}
+
+func TestSMAFrom(t *testing.T) {
+	base := time.Date(2018, 12, 26, 18, 0, 0, 0, time.UTC)
+	store := newEventStore(0, 24*time.Hour)
+	for _, e := range []struct {
+		minute int
+		client string
+	}{{0, "airliberty"}, {2, "taxi-eats"}, {14, "airliberty"}, {40, "taxi-eats"}, {43, "airliberty"}} {
+		store.add(event{Timestamp: customTime{base.Add(time.Duration(e.minute) * time.Minute)}, ClientName: e.client, Duration: e.minute})
+	}
+	opts := smaOptions{Window: 10 * time.Minute, GroupBy: []string{"client_name"}, Stats: []string{"count"}, DropDrained: true}
+	require.Equal(t, base.Add(25*time.Minute), smaFrom(opts, 9, store.latest()))
+
+	var all []output
+	require.NoError(t, StreamSMA(&sliceSource{events: store.snapshot()}, engines["fifo"], opts, funcSink(func(row output) error {
+		all = append(all, row)
+		return nil
+	})))
+	for _, last := range []int{1, 5, 20, 32, 50} {
+		// the rows of the last buckets are the same from the events smaFrom tells.
+		var got []output
+		require.NoError(t, StreamSMA(&sliceSource{events: store.since(smaFrom(opts, last, store.latest()))}, engines["fifo"], opts, funcSink(func(row output) error {
+			got = append(got, row)
+			return nil
+		})))
+		from := all[len(all)-1].Date.Add(-time.Duration(last-1) * time.Minute)
+		want := all[sort.Search(len(all), func(i int) bool { return !all[i].Date.Before(from) }):]
+		got = got[sort.Search(len(got), func(i int) bool { return !got[i].Date.Before(from) }):]
+		require.Equal(t, want, got, "last %d", last)
+	}
+	require.True(t, smaFrom(smaOptions{Window: time.Minute, Metric: "ema"}, 1, store.latest()).IsZero(), "ema needs every event")
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv := newServer(newEventStore(0, time.Hour), engines["buffifo"], 1<<20)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.serve(ctx, l)
+	}()
+
+	status, _ := doRequest(t, http.MethodGet, "http://"+l.Addr().String()+"/sma", "")
+	require.Equal(t, http.StatusOK, status)
+
+	cancel()
+	require.NoError(t, <-done)
+	_, err = http.Get("http://" + l.Addr().String() + "/sma")
+	require.Error(t, err)
+}
+
+func TestServerStream(t *testing.T) {
+	srv := newServer(newEventStore(0, 24*time.Hour), engines["buffifo"], 1<<20)
+	require.NoError(t, srv.startLive(smaOptions{Window: 10 * time.Minute, GroupBy: []string{"client_name"}, Late: lateDrop}, 16, 60))
+	ts := httptest.NewServer(srv.routes())
+	t.Cleanup(ts.Close)
+
+	status, body := doRequest(t, http.MethodGet, ts.URL+"/stream?client=taxi-eats", "")
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, `invalid query: "client" isn't a --group-by field, filter by any of: client_name`+"\n", body)
+
+	resp, err := http.Get(ts.URL + "/stream?client_name=taxi-eats")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	status, _ = doRequest(t, http.MethodPost, ts.URL+"/events", string(input))
+	require.Equal(t, http.StatusAccepted, status)
+	// the minute of the last event closes once a later one arrives.
+	status, _ = doRequest(t, http.MethodPost, ts.URL+"/events", `{"timestamp":"2018-12-26 18:25:00","client_name":"airliberty","duration":1}`)
+	require.Equal(t, http.StatusAccepted, status)
+
+	scanner := bufio.NewScanner(resp.Body)
+	var got []string
+	for len(got) < 2 && scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			got = append(got, data)
+		}
+	}
+	require.Equal(t, []string{
+		`{"date":"2018-12-26 18:23:00","client_name":"taxi-eats","average_delivery_time":0}`,
+		`{"date":"2018-12-26 18:24:00","client_name":"taxi-eats","average_delivery_time":54}`,
+	}, got)
+
+	require.NoError(t, srv.stopLive())
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err, "stopping ends the stream")
+}
+
+func TestServerLiveSMA(t *testing.T) {
+	srv := newServer(newEventStore(0, 24*time.Hour), engines["buffifo"], 1<<20)
+	require.NoError(t, srv.startLive(smaOptions{Window: 10 * time.Minute, GroupBy: []string{"client_name"}, Late: lateDrop}, 16, 5))
+	ts := httptest.NewServer(srv.routes())
+	t.Cleanup(ts.Close)
+
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	status, _ := doRequest(t, http.MethodPost, ts.URL+"/events", string(input))
+	require.Equal(t, http.StatusAccepted, status)
+	status, _ = doRequest(t, http.MethodPost, ts.URL+"/events", `{"timestamp":"2018-12-26 18:30:00","client_name":"flyhigh","duration":1}`)
+	require.Equal(t, http.StatusAccepted, status)
+	// rows asked with the live options don't need the stored events.
+	srv.store = newEventStore(0, 24*time.Hour)
+
+	tcs := []struct {
+		name     string
+		query    string
+		wantBody string
+	}{
+		{
+			name:  "when asked for the live options should answer from the live rows",
+			query: "window=10&group_by=client_name&stats=avg&last=2",
+			wantBody: `{"date":"2018-12-26 18:29:00","client_name":"taxi-eats","average_delivery_time":54}
+{"date":"2018-12-26 18:30:00","client_name":"flyhigh","average_delivery_time":0}
+{"date":"2018-12-26 18:30:00","client_name":"taxi-eats","average_delivery_time":54}
+`,
+		},
+		{
+			name:  "when a group drained should leave it out",
+			query: "window=10m&step=1m&group_by=client_name&last=5",
+			wantBody: `{"date":"2018-12-26 18:26:00","client_name":"airliberty","average_delivery_time":0}
+{"date":"2018-12-26 18:26:00","client_name":"taxi-eats","average_delivery_time":54}
+{"date":"2018-12-26 18:27:00","client_name":"taxi-eats","average_delivery_time":54}
+{"date":"2018-12-26 18:28:00","client_name":"taxi-eats","average_delivery_time":54}
+{"date":"2018-12-26 18:29:00","client_name":"taxi-eats","average_delivery_time":54}
+{"date":"2018-12-26 18:30:00","client_name":"flyhigh","average_delivery_time":0}
+{"date":"2018-12-26 18:30:00","client_name":"taxi-eats","average_delivery_time":54}
+`,
+		},
+		{
+			name:  "when asked for more than the rows kept should calculate them",
+			query: "window=10&group_by=client_name&last=6",
+		},
+		{
+			name:  "when asked for other options should calculate them",
+			query: "window=5&group_by=client_name",
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := doRequest(t, http.MethodGet, ts.URL+"/sma?"+tc.query, "")
+			require.Equal(t, http.StatusOK, status)
+			require.Equal(t, tc.wantBody, body)
+		})
+	}
+	for _, row := range srv.latest.sorted() {
+		require.NotEqual(t, "airliberty", row.Labels[0].Value, "drained groups are dropped from the latest rows too")
+	}
+}
+
+func TestServerCheckpoint(t *testing.T) {
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	var raw []json.RawMessage
+	require.NoError(t, json.Unmarshal(input, &raw))
+	opts := smaOptions{Window: 10 * time.Minute, GroupBy: []string{"client_name"}, Stats: []string{"count"}, Late: lateDrop}
+	last := `{"timestamp":"2018-12-26 18:30:00","client_name":"airliberty","duration":1}`
+
+	// start runs a server over the checkpoint, and returns it with its url.
+	start := func(checkpoint string) (*server, string) {
+		srv, ts := newTestServer(t, 0)
+		require.NoError(t, srv.startLive(opts, 16, 60))
+		srv.checkpoint = checkpoint
+		return srv, ts.URL
+	}
+	post := func(url string, events ...string) {
+		status, _ := doRequest(t, http.MethodPost, url+"/events", strings.Join(events, "\n"))
+		require.Equal(t, http.StatusAccepted, status)
+	}
+
+	want, wantURL := start("")
+	post(wantURL, string(raw[0]), string(raw[1]), string(raw[2]), last)
+
+	checkpoint := filepath.Join(t.TempDir(), "state.bin")
+	first, url := start(checkpoint)
+	post(url, string(raw[0]), string(raw[1]))
+	require.NoError(t, first.stopLive())
+
+	second, url := start(checkpoint)
+	require.NoError(t, second.resume())
+	post(url, string(raw[2]), last)
+
+	require.Equal(t, want.latest.sorted(), second.latest.sorted())
+	_, wantBody := doRequest(t, http.MethodGet, wantURL+"/sma?window=10&last=20", "")
+	_, body := doRequest(t, http.MethodGet, url+"/sma?window=10&last=20", "")
+	require.Equal(t, wantBody, body, "stored events are loaded too")
+}
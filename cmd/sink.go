@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrWriteOutput = errors.New("unable to write output")
+
+// sink receives output rows as soon as each bucket is done.
+// Rows come ordered by date, and by group within the same date, so there's
+// no need to keep them around and sort them before writing.
+type sink interface {
+	Emit(row output) error
+}
+
+// writerSink writes each row as a json line to w.
+// Flush must be called once no more rows are coming.
+type writerSink struct {
+	bw *bufio.Writer
+	// flush writes every row right away, e.g. with --follow rows are wanted as minutes close.
+	flush bool
+	// layout overrides the date layout of rows, see --date-format.
+	layout string
+}
+
+// newWriterSink creates a writerSink buffering writes to w.
+func newWriterSink(w io.Writer) *writerSink {
+	return &writerSink{bw: bufio.NewWriter(w)}
+}
+
+// Emit writes the row followed by a new line for output readability.
+func (s *writerSink) Emit(row output) error {
+	if err := s.writeRow(row); err != nil {
+		return err
+	}
+	if err := s.bw.WriteByte('\n'); err != nil {
+		return err
+	}
+	if s.flush {
+		return s.bw.Flush()
+	}
+	return nil
+}
+
+// writeRow writes the row json.
+func (s *writerSink) writeRow(row output) error {
+	if s.layout != "" {
+		row.layout = s.layout
+	}
+	bs, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	_, err = s.bw.Write(bs)
+	return err
+}
+
+// Flush writes any buffered rows to the underlying writer.
+func (s *writerSink) Flush() error {
+	return s.bw.Flush()
+}
+
+// Close flushes, json lines need nothing else to end.
+func (s *writerSink) Close() error {
+	return s.bw.Flush()
+}
+
+// chanSink sends rows to a channel, blocking until they are received.
+type chanSink chan<- output
+
+// Emit sends the row to the channel.
+func (s chanSink) Emit(row output) error {
+	s <- row
+	return nil
+}
+
+// funcSink calls a func for each row, e.g. to collect them in tests.
+type funcSink func(row output) error
+
+// Emit calls the func with the row.
+func (f funcSink) Emit(row output) error {
+	return f(row)
+}
+
+// teeSink emits each row to every sink, in order, stopping at the first error.
+type teeSink []sink
+
+// Emit hands the row to every sink.
+func (t teeSink) Emit(row output) error {
+	for _, s := range t {
+		if err := s.Emit(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventWriter writes events as json lines to w, e.g. late events left out of the sma.
+type eventWriter struct {
+	bw *bufio.Writer
+}
+
+// newEventWriter creates an eventWriter buffering writes to w.
+func newEventWriter(w io.Writer) *eventWriter {
+	return &eventWriter{bw: bufio.NewWriter(w)}
+}
+
+// Write writes the event followed by a new line.
+func (w *eventWriter) Write(e event) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+	_, err = w.bw.Write(bs)
+	return err
+}
+
+// Flush writes any buffered events to the underlying writer.
+func (w *eventWriter) Flush() error {
+	return w.bw.Flush()
+}
+
+// lateOutput counts late events, and writes them to --late-events when given.
+type lateOutput struct {
+	count int
+	// w writes to written, both are nil without --late-events.
+	w       *eventWriter
+	written *countingWriter
+}
+
+// newLateOutput creates a lateOutput writing to w, counts carry on from a checkpoint.
+func newLateOutput(w io.Writer, count int, offset int64) *lateOutput {
+	l := &lateOutput{count: count}
+	if w != nil {
+		l.written = &countingWriter{w: w, n: offset}
+		l.w = newEventWriter(l.written)
+	}
+	return l
+}
+
+// handle counts a late event and writes it, it's the OnLate of the streamer.
+func (l *lateOutput) handle(e event) error {
+	l.count++
+	if l.w == nil {
+		return nil
+	}
+	if err := l.w.Write(e); err != nil {
+		return fmt.Errorf("%w: %v", ErrWriteOutput, err)
+	}
+	return nil
+}
+
+// Flush writes buffered late events.
+func (l *lateOutput) Flush() error {
+	if l.w == nil {
+		return nil
+	}
+	return l.w.Flush()
+}
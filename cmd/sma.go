@@ -1,18 +1,76 @@
 package cmd
 
 import (
+	"io"
 	"time"
 )
 
 // output representes an event in the output file.
 type output struct {
-	Date            time.Time `json:"date"` //2018-12-26 18:11:00
-	AvgDeliveryTime float32   `json:"average_delivery_time"`
+	Date time.Time `json:"date"` //2018-12-26 18:11:00
+	// layout is the date layout, empty for defaultDateLayout.
+	layout string
+	// null rows mark the start of a gap with --gaps null, all values are written as null.
+	null bool
+	// correction rows replace a row already written, after a late event with --late correct.
+	correction bool
+	// Labels are the --group-by fields of the row, empty when not grouping.
+	Labels          []label `json:"-"`
+	AvgDeliveryTime float32 `json:"average_delivery_time"`
+	// Fields are extra values of the row, e.g. p90, written after the avg.
+	Fields []field `json:"-"`
 }
 
-// SMA calculates the SMA for a given slice of events and writes in
-// an output file. Incoming events will be ordered by timestamp.
-func SMA(events []event, window int32) map[time.Time]output {
+// field is an extra named value of an output row.
+// Value is a float32 like the avg, or an int64 for counts and sums.
+type field struct {
+	Name  string
+	Value any
+}
+
+// smaOptions are the settings of a StreamSMA run.
+type smaOptions struct {
+	// Window is the time window considered in the sma, e.g. 10m.
+	Window time.Duration
+	// Step is the bucket granularity, one output row per step, 1m by default.
+	Step time.Duration
+	// GroupBy are the event fields used to split events in separate series.
+	GroupBy []string
+	// Metric is the moving average to calculate: sma(default), ema or wma.
+	Metric string
+	// Alpha is the ema smoothing factor, HalfLife is an alternative way to set it.
+	Alpha    float64
+	HalfLife time.Duration
+	// Quantiles of the duration to add to each row, e.g. 0.5, 0.9 and 0.99.
+	Quantiles []float64
+	// QuantileMode is exact(default) or approx, QuantileAccuracy is the approx relative accuracy.
+	QuantileMode     string
+	QuantileAccuracy float64
+	// Stats to add to each row, e.g. count, min and max.
+	Stats []string
+	// Gaps is what to write for buckets with an empty window: zero(default), carry, skip or null.
+	Gaps string
+	// AllowedLateness is how far behind the most recent event an event can be and still be in its rows.
+	AllowedLateness time.Duration
+	// Late is what to do with events later than that: fail(default), drop or correct.
+	Late string
+	// OnLate is called with late events left out of the sma, it can be nil.
+	OnLate func(e event) error
+	// DropDrained removes a group once its window is empty and its row of that bucket was
+	// emitted, it comes back with its next event. It keeps memory bounded on a live feed
+	// where new group values keep showing up. OnDrop is called with its labels, it can be nil.
+	DropDrained bool
+	OnDrop      func(labels []label)
+}
+
+// SMA calculates the SMA for a given slice of events and emits it to out
+// minute by minute. Incoming events must be ordered by timestamp, otherwise an
+// unsortedError is returned.
+func SMA(events []event, window int32, out sink) error {
+	if err := checkSorted(events); err != nil {
+		return err
+	}
+
 	// we want to calculate sma for the translation delivery time over the last X minutes.
 	// window is already defined.
 	// incoming events are already sorted.
@@ -32,26 +90,32 @@ func SMA(events []event, window int32) map[time.Time]output {
 	// 3rd window(W3) will be: W3 = W2+1min
 	// Nth window(WN) will be: WN = W(N-1)+1min.
 
-	result := make(map[time.Time]output)
 	// lets find all windows.
 	// TODO: rethink this logic of finding all windows, we don't need to find them all,
 	// we get the first then iterate/increase them by 1 min.
 	windows := findAllWindows(events[:1][0], events[len(events)-1:][0], window)
 
-	// here we iterate over all windows(ordered and asc)
+	// here we iterate over all windows(ordered and asc), windows are one minute apart
+	// so we step minute by minute instead of ranging over the map in random order,
 	// and will get avg delivery time for events that fit in the given window.
-	for k, v := range windows {
+	for k := getMinute(events[0]); ; k = k.Add(time.Minute) {
+		v, ok := windows[k]
+		if !ok {
+			break
+		}
 		// getAvgDeliveryTimeForWindow is itarating over ALL data, N times, where N is the lenght of windows!
 		// BAD DECISION!! but let's make it work, then we make it beautiful! ;D
 		// complexity: O(nm).
 		avg := getAvgDeliveryTimeForWindow(events, v)
-		result[k] = output{
+		if err := out.Emit(output{
 			Date:            k,
 			AvgDeliveryTime: avg,
+		}); err != nil {
+			return err
 		}
 	}
 
-	return result
+	return nil
 }
 
 // getAvgDeliveryTimeForWindow will range over events
@@ -60,8 +124,8 @@ func SMA(events []event, window int32) map[time.Time]output {
 func getAvgDeliveryTimeForWindow(events []event, window []time.Time) float32 {
 	var sum, count float32
 	for _, event := range events {
-		if event.Timestamp.Time.After(window[0]) && event.Timestamp.Before(window[1]) {
-			// event time is between window.
+		if !event.Timestamp.Time.Before(window[0]) && event.Timestamp.Before(window[1]) {
+			// event time is between window, an event exactly window old is still in it like in FIFO.
 			sum += float32(event.Duration)
 			count++
 		}
@@ -105,6 +169,53 @@ func findAllWindows(first, last event, window int32) map[time.Time][]time.Time {
 	return windows
 }
 
+// naiveQueue keeps every event it was given and, like SMA, goes over all of them
+// to calculate the avg of each minute. It's here only as the baseline engine,
+// memory grows with every event so it can't run for long, see unboundedEngines.
+type naiveQueue struct {
+	events []event
+	window []time.Time
+	// evicted is how many events already left the window, only used to call onEvict once per event.
+	evicted int
+}
+
+// Enqueue keeps the event forever.
+func (q *naiveQueue) Enqueue(item event) {
+	q.events = append(q.events, item)
+}
+
+// Evict doesn't remove anything, it only moves the window boundaries.
+func (q *naiveQueue) Evict(currBucket time.Time, window time.Duration, onEvict func(e event)) {
+	q.window = []time.Time{currBucket.Add(-window), currBucket}
+	for q.evicted < len(q.events) && q.events[q.evicted].Timestamp.Before(q.window[0]) {
+		if onEvict != nil {
+			onEvict(q.events[q.evicted])
+		}
+		q.evicted++
+	}
+}
+
+// Avg goes over all events to find the ones inside the window.
+func (q *naiveQueue) Avg() float32 {
+	return getAvgDeliveryTimeForWindow(q.events, q.window)
+}
+
+// Aggregates goes over all events inside the window to sum them.
+func (q *naiveQueue) Aggregates() windowAgg {
+	var agg windowAgg
+	q.Each(agg.add)
+	return agg
+}
+
+// Each goes over all events and calls fn for the ones inside the window.
+func (q *naiveQueue) Each(fn func(e event)) {
+	for _, e := range q.events {
+		if !e.Timestamp.Time.Before(q.window[0]) && e.Timestamp.Before(q.window[1]) {
+			fn(e)
+		}
+	}
+}
+
 func getMinute(v event) time.Time {
 	return v.Timestamp.Truncate(time.Minute)
 }
@@ -145,11 +256,12 @@ func getMinuteDiffRange(v time.Time, window int32) time.Time {
 
 // This was our first FIFO implementation.
 // FIFOSMA calculates sma using FIFO to hold events and avoid iterating over all events.
-func FIFOSMA(events []event, window int32) map[time.Time]output {
+func FIFOSMA(events []event, window int32, out sink) error {
+	if err := checkSorted(events); err != nil {
+		return err
+	}
 	fifo := NewFIFO()
 
-	result := make(map[time.Time]output)
-
 	// we want SMA for minute
 	// identify range of minutes
 	// iterate over minutes
@@ -217,23 +329,27 @@ func FIFOSMA(events []event, window int32) map[time.Time]output {
 		// calculate sma for current fifo.
 		avg := calculateAvg(fifo)
 
-		// add to result map.
-		result[currMinute] = output{
+		// emit it right away, minutes are already in order so there's nothing to keep or sort.
+		if err := out.Emit(output{
 			Date:            currMinute,
 			AvgDeliveryTime: avg,
+		}); err != nil {
+			return err
 		}
 
 		// increase minute.
 		currMinute = currMinute.Add(time.Minute)
 	}
 
-	return result
+	return nil
 }
 
 // FIFOSMA without comments to make profiling visibility better to understand.
-func FIFOSMAMinified(events []event, window int32) map[time.Time]output {
+func FIFOSMAMinified(events []event, window int32, out sink) error {
+	if err := checkSorted(events); err != nil {
+		return err
+	}
 	fifo := NewFIFO()
-	result := make(map[time.Time]output)
 	currMinute := getMinute(events[:1][0])
 	end := getMinute(events[len(events)-1:][0])
 
@@ -246,17 +362,78 @@ func FIFOSMAMinified(events []event, window int32) map[time.Time]output {
 		}
 		fifo.queue = dequeueByTime(currMinute, fifo, window)
 		avg := calculateAvg(fifo)
-		result[currMinute] = output{
+		if err := out.Emit(output{
 			Date:            currMinute,
 			AvgDeliveryTime: avg,
+		}); err != nil {
+			return err
 		}
 		currMinute = currMinute.Add(time.Minute)
 	}
-	return result
+	return nil
 }
 
-func BuffFIFOSMA(events []event, window int32) map[time.Time]output {
-	result := make(map[time.Time]output)
+// StreamSMA is FIFOSMAMinified fed by an eventSource instead of a slice and
+// keeping the window in queues created by newQueue, which is what tells engines apart.
+// Events are pulled one at a time as buckets advance, so memory depends on the
+// window size and not on the input size.
+// Buckets are minutes by default, but any opts.Step works the same way.
+// Since we don't know the last event upfront, we keep going while the source has
+// events and then until last event bucket + 1 step, like FIFOSMA does.
+// Each group gets its own queue, and rows are emitted for every group from the
+// bucket of its first event.
+// Rows are emitted to out as soon as each bucket is done, ordered by bucket and
+// group key, so nothing but the windows is kept in memory.
+// Events up to opts.AllowedLateness out of order are sorted back, later ones are
+// handled according to opts.Late, with lateCorrect correction rows are emitted right away.
+// Errors from out are wrapped with ErrWriteOutput.
+func StreamSMA(src eventSource, newQueue func() windowQueue, opts smaOptions, out sink) error {
+	s, err := newStreamerFor(newQueue, opts, out)
+	if err != nil {
+		return err
+	}
+
+	// the loop itself lives in streamer, here we only pull events and push them to it.
+	for {
+		e, err := src.Next()
+		if err == io.EOF {
+			return s.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.Push(e); err != nil {
+			return err
+		}
+	}
+}
+
+// withDefaults returns opts with a 1m step when no step was set.
+func (o smaOptions) withDefaults() smaOptions {
+	if o.Step == 0 {
+		o.Step = time.Minute
+	}
+	return o
+}
+
+// validate checks window and step are positive, and gaps and late modes.
+func (o smaOptions) validate() error {
+	if o.Window <= 0 {
+		return ErrInvalidWindow
+	}
+	if o.Step <= 0 {
+		return ErrInvalidStep
+	}
+	if err := validateGaps(o.Gaps); err != nil {
+		return err
+	}
+	return validateLate(o)
+}
+
+func BuffFIFOSMA(events []event, window int32, out sink) error {
+	if err := checkSorted(events); err != nil {
+		return err
+	}
 	currMinute := getMinute(events[:1][0])
 	end := getMinute(events[len(events)-1:][0])
 	// we might need to start with some capacity!
@@ -275,13 +452,15 @@ func BuffFIFOSMA(events []event, window int32) map[time.Time]output {
 			currEventIndex++
 		}
 
-		fifo.dequeueBuffFIFOByTime(currMinute, window)
+		fifo.dequeueBuffFIFOByTime(currMinute, time.Minute*time.Duration(window), nil)
 		avg := calculateAvgFromBuffFIFO(fifo)
-		result[currMinute] = output{
+		if err := out.Emit(output{
 			Date:            currMinute,
 			AvgDeliveryTime: avg,
+		}); err != nil {
+			return err
 		}
 		currMinute = currMinute.Add(time.Minute)
 	}
-	return result
+	return nil
 }
@@ -1,8 +1,14 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
+	"os"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,6 +25,8 @@ import (
 // to check performance improvement.
 // For now lets use 100k entries.
 
+// generateEventsArray generates numEntries random events, sorted by timestamp
+// since the sma functions need sorted input.
 func generateEventsArray(t *testing.B, numEntries int) []event {
 	starTime := time.Now().UTC()
 	events := make([]event, numEntries)
@@ -30,6 +38,7 @@ func generateEventsArray(t *testing.B, numEntries int) []event {
 		// increase event timestamp over time.
 		starTime = starTime.Add(time.Minute)
 	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp.Time) })
 	return events
 }
 
@@ -48,46 +57,80 @@ func generateRandomEvent(baseTime time.Time) (event, error) {
 }
 
 // Prevent inlining of 'leaf functions' and avoid compiler optimizations.
-var result map[time.Time]output
+// Rows are emitted to a sink counting them, so results don't take any memory.
+var result int
+
+// countSink returns a sink counting emitted rows in n.
+func countSink(n *int) sink {
+	return funcSink(func(output) error {
+		*n++
+		return nil
+	})
+}
 
 func BenchmarkSMA(b *testing.B) {
 	// local sink.
-	var r map[time.Time]output
+	var r int
 	window := int32(10)
 	events := generateEventsArray(b, _100K)
 	// It's important to not record any setup that is required to run your benchmark.
 	b.ResetTimer()
 	// execute code to benchmark here:
 	for i := 0; i < b.N; i++ {
-		r = SMA(events, window)
+		require.NoError(b, SMA(events, window, countSink(&r)))
 	}
 	result = r
 }
 
 func BenchmarkFIFOSMA(b *testing.B) {
 	// local sink.
-	var r map[time.Time]output
+	var r int
 	events := generateEventsArray(b, _100K)
 	window := int32(10)
 	// It's important to not record any setup that is required to run your benchmark.
 	b.ResetTimer()
 	// execute code to benchmark here:
 	for i := 0; i < b.N; i++ {
-		FIFOSMAMinified(events, window)
+		require.NoError(b, FIFOSMAMinified(events, window, countSink(&r)))
 	}
 	result = r
 }
 
+// BenchmarkSMAWindow compares a 10 minutes window with a day long one(1440 minutes).
+// Since the avg comes from running sums, updated on enqueue and dequeue, the bigger window
+// should cost about the same, even though it holds ~144 times more events.
+// make benchwindow
+func BenchmarkSMAWindow(b *testing.B) {
+	events := generateEventsArray(b, _100K)
+	for _, window := range []int32{10, 1440} {
+		window := window
+		b.Run(fmt.Sprintf("FIFOSMA/window=%d", window), func(b *testing.B) {
+			var r int
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, FIFOSMAMinified(events, window, countSink(&r)))
+			}
+			result = r
+		})
+		b.Run(fmt.Sprintf("BuffFIFOSMA/window=%d", window), func(b *testing.B) {
+			var r int
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, BuffFIFOSMA(events, window, countSink(&r)))
+			}
+			result = r
+		})
+	}
+}
+
 func BenchmarkBuffFIFOSMA(b *testing.B) {
 	// local sink.
-	var r map[time.Time]output
+	var r int
 	events := generateEventsArray(b, _100K)
 	window := int32(10)
 	// It's important to not record any setup that is required to run your benchmark.
 	b.ResetTimer()
 	// execute code to benchmark here:
 	for i := 0; i < b.N; i++ {
-		BuffFIFOSMA(events, window)
+		require.NoError(b, BuffFIFOSMA(events, window, countSink(&r)))
 	}
 	result = r
 }
@@ -95,25 +138,30 @@ func BenchmarkBuffFIFOSMA(b *testing.B) {
 func TestAllSMAs(t *testing.T) {
 	tcs := []struct {
 		name        string
-		callSMSFunc func([]event) map[time.Time]output
-		checkResult func(got map[time.Time]output)
+		callSMSFunc func([]event, sink) error
 	}{
 		{
 			name: "when SMA called should create result output",
-			callSMSFunc: func(events []event) map[time.Time]output {
-				return SMA(events, 10)
+			callSMSFunc: func(events []event, out sink) error {
+				return SMA(events, 10, out)
 			},
 		},
 		{
 			name: "when FIFOSMA called should create result output",
-			callSMSFunc: func(events []event) map[time.Time]output {
-				return FIFOSMAMinified(events, 10)
+			callSMSFunc: func(events []event, out sink) error {
+				return FIFOSMA(events, 10, out)
+			},
+		},
+		{
+			name: "when FIFOSMAMinified called should create result output",
+			callSMSFunc: func(events []event, out sink) error {
+				return FIFOSMAMinified(events, 10, out)
 			},
 		},
 		{
 			name: "when BuffFIFOSMA called should create result output",
-			callSMSFunc: func(events []event) map[time.Time]output {
-				return BuffFIFOSMA(events, 10)
+			callSMSFunc: func(events []event, out sink) error {
+				return BuffFIFOSMA(events, 10, out)
 			},
 		},
 	}
@@ -123,21 +171,191 @@ func TestAllSMAs(t *testing.T) {
 	for _, tc := range tcs {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
+			var got []output
+			err := tc.callSMSFunc(events, funcSink(func(row output) error {
+				got = append(got, row)
+				return nil
+			}))
+			require.NoError(t, err)
 
-			got := tc.callSMSFunc(events)
 			want := createWantOutput()
-
 			if len(want) != len(got) {
-				t.Fatalf("Expected map length %d, got %d", len(want), len(got))
+				t.Fatalf("Expected %d rows, got %d", len(want), len(got))
 			}
-			for k, v := range want {
-				if got[k] != v {
-					t.Errorf("At %v: expected %+v, got %+v", k, v, got[k])
+			for i, row := range got {
+				// rows must come already ordered.
+				if i > 0 {
+					require.True(t, got[i-1].Date.Before(row.Date), "At %v", row.Date)
 				}
+				require.Equal(t, want[row.Date], row, "At %v", row.Date)
 			}
+		})
+	}
+}
+
+func TestSinks(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+
+	t.Run("when sink is a writer should write json lines", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := newWriterSink(&buf)
+		require.NoError(t, FIFOSMAMinified(events, 10, out))
+		require.NoError(t, out.Flush())
+
+		want, err := os.ReadFile("./testResult.txt")
+		require.NoError(t, err)
+		require.Equal(t, string(want), buf.String())
+	})
+
+	t.Run("when sink is a channel should receive rows in order", func(t *testing.T) {
+		rows := make(chan output)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- StreamSMA(&sliceSource{events: events}, engines["fifo"], smaOptions{Window: 10 * time.Minute}, chanSink(rows))
+			close(rows)
+		}()
+
+		var got []time.Time
+		for row := range rows {
+			got = append(got, row.Date)
+		}
+		require.NoError(t, <-errc)
+		require.Len(t, got, 14)
+		require.True(t, sort.SliceIsSorted(got, func(i, j int) bool { return got[i].Before(got[j]) }))
+	})
+
+	t.Run("when sink fails should stop and return its error", func(t *testing.T) {
+		errFull := errors.New("full")
+		calls := 0
+		err := StreamSMA(&sliceSource{events: events}, engines["fifo"], smaOptions{Window: 10 * time.Minute},
+			funcSink(func(output) error {
+				calls++
+				return errFull
+			}))
+		require.ErrorIs(t, err, ErrWriteOutput)
+		require.Equal(t, 1, calls)
+	})
+}
+
+func TestEngines(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+
+	for name := range engines {
+		name := name
+		t.Run(fmt.Sprintf("when engine %s called should create result output", name), func(t *testing.T) {
+			newQueue, err := engineQueueFactory(name)
+			require.NoError(t, err)
 
+			got, err := collectSMA(&sliceSource{events: events}, newQueue, smaOptions{Window: 10 * time.Minute})
+			require.NoError(t, err)
+			require.Equal(t, createWantResult(), got)
 		})
 	}
+
+	_, err = engineQueueFactory("unknown")
+	require.ErrorIs(t, err, ErrUnknownEngine)
+	_, err = longRunningQueueFactory("naive")
+	require.ErrorIs(t, err, ErrUnboundedEngine)
+}
+
+func TestEnginesWindowBoundary(t *testing.T) {
+	// the first event is exactly window old at 18:02 and 18:07.
+	events := []event{
+		{Timestamp: customTime{time.Date(2018, 12, 26, 18, 0, 0, 0, time.UTC)}, Duration: 10},
+		{Timestamp: customTime{time.Date(2018, 12, 26, 18, 5, 0, 0, time.UTC)}, Duration: 20},
+	}
+	opts := smaOptions{Window: 2 * time.Minute, Stats: []string{"count"}}
+
+	want, err := collectSMA(&sliceSource{events: events}, engines["fifo"], opts)
+	require.NoError(t, err)
+	at := resultKey{Date: time.Date(2018, 12, 26, 18, 2, 0, 0, time.UTC)}
+	require.Equal(t, float32(10), want[at].AvgDeliveryTime)
+	require.Equal(t, []field{{Name: "count", Value: int64(1)}}, want[at].Fields)
+
+	for name := range engines {
+		name := name
+		t.Run(fmt.Sprintf("when engine %s called should keep events exactly window old", name), func(t *testing.T) {
+			got, err := collectSMA(&sliceSource{events: events}, engines[name], opts)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		})
+	}
+
+	t.Run("when SMA called should keep events exactly window old", func(t *testing.T) {
+		var sma, fifo []output
+		require.NoError(t, SMA(events, 2, funcSink(func(row output) error { sma = append(sma, row); return nil })))
+		require.NoError(t, FIFOSMA(events, 2, funcSink(func(row output) error { fifo = append(fifo, row); return nil })))
+		// SMA stops at the minute of the last event, FIFOSMA has one row more.
+		require.Equal(t, fifo[:len(sma)], sma)
+	})
+}
+
+func TestStreamSMAGroupBy(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+
+	got, err := collectSMA(&sliceSource{events: events}, engines["fifo"], smaOptions{
+		Window:  10 * time.Minute,
+		GroupBy: []string{"client_name"},
+	})
+	require.NoError(t, err)
+
+	// airliberty has events at 18:11 and 18:15, taxi-eats only at 18:23.
+	airliberty := []label{{Name: "client_name", Value: "airliberty"}}
+	taxiEats := []label{{Name: "client_name", Value: "taxi-eats"}}
+	at := func(s string) time.Time {
+		date, err := time.Parse("2006-01-02 15:04:05", s)
+		require.NoError(t, err)
+		return date
+	}
+
+	require.Len(t, got, 14+2)
+	require.Equal(t, output{Date: at("2018-12-26 18:11:00"), Labels: airliberty, AvgDeliveryTime: 0},
+		got[resultKey{Date: at("2018-12-26 18:11:00"), Group: "airliberty"}])
+	require.Equal(t, output{Date: at("2018-12-26 18:16:00"), Labels: airliberty, AvgDeliveryTime: 25.5},
+		got[resultKey{Date: at("2018-12-26 18:16:00"), Group: "airliberty"}])
+	require.Equal(t, output{Date: at("2018-12-26 18:24:00"), Labels: airliberty, AvgDeliveryTime: 31},
+		got[resultKey{Date: at("2018-12-26 18:24:00"), Group: "airliberty"}])
+	require.Equal(t, output{Date: at("2018-12-26 18:23:00"), Labels: taxiEats, AvgDeliveryTime: 0},
+		got[resultKey{Date: at("2018-12-26 18:23:00"), Group: "taxi-eats"}])
+	require.Equal(t, output{Date: at("2018-12-26 18:24:00"), Labels: taxiEats, AvgDeliveryTime: 54},
+		got[resultKey{Date: at("2018-12-26 18:24:00"), Group: "taxi-eats"}])
+
+	bs, err := json.Marshal(got[resultKey{Date: at("2018-12-26 18:24:00"), Group: "taxi-eats"}])
+	require.NoError(t, err)
+	require.Equal(t, `{"date":"2018-12-26 18:24:00","client_name":"taxi-eats","average_delivery_time":54}`, string(bs))
+}
+
+// resultKey identifies a StreamSMA row: a bucket of a given group.
+type resultKey struct {
+	Date  time.Time
+	Group string
+}
+
+// collectSMA runs StreamSMA and collects emitted rows keyed by bucket and group.
+// Group is the label values joined like groupSet keys.
+func collectSMA(src eventSource, newQueue func() windowQueue, opts smaOptions) (map[resultKey]output, error) {
+	got := make(map[resultKey]output)
+	err := StreamSMA(src, newQueue, opts, funcSink(func(row output) error {
+		values := make([]string, len(row.Labels))
+		for i, l := range row.Labels {
+			values[i] = l.Value
+		}
+		got[resultKey{Date: row.Date, Group: strings.Join(values, "\x1f")}] = row
+		return nil
+	}))
+	return got, err
+}
+
+// createWantResult is createWantOutput keyed as collectSMA results.
+func createWantResult() map[resultKey]output {
+	want := make(map[resultKey]output)
+	for k, v := range createWantOutput() {
+		want[resultKey{Date: k}] = v
+	}
+	return want
 }
 
 func createWantOutput() map[time.Time]output {
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+var ErrUnsortedInput = errors.New("events are not sorted by timestamp, use --sort")
+var ErrUnknownSort = errors.New("unknown sort mode")
+
+const (
+	// sortNone expects the input to be sorted already, it's checked as events are read.
+	sortNone = "none"
+	// sortMemory loads all events and sorts them before the sma.
+	sortMemory = "memory"
+	// sortExternal sorts runs of events in memory, spills them to temp files and merges them,
+	// for inputs that don't fit in memory.
+	sortExternal = "external"
+)
+
+// defaultSortRun is how many events are sorted in memory at once by sortExternal.
+const defaultSortRun = 1 << 20
+
+// unsortedError tells where the input stopped being sorted.
+type unsortedError struct {
+	// index is the position(starting at 1) of the event among the events read.
+	index int
+	ts    time.Time
+	prev  time.Time
+}
+
+func (e *unsortedError) Error() string {
+	return fmt.Sprintf("%v: event %d at %s is before the previous one at %s",
+		ErrUnsortedInput, e.index, e.ts.Format(timestampLayout), e.prev.Format(timestampLayout))
+}
+
+// Unwrap makes errors.Is(err, ErrUnsortedInput) work.
+func (e *unsortedError) Unwrap() error {
+	return ErrUnsortedInput
+}
+
+// checkSorted returns an unsortedError for the first event before its previous one.
+func checkSorted(events []event) error {
+	for i := 1; i < len(events); i++ {
+		if events[i].Timestamp.Before(events[i-1].Timestamp.Time) {
+			return &unsortedError{index: i + 1, ts: events[i].Timestamp.Time, prev: events[i-1].Timestamp.Time}
+		}
+	}
+	return nil
+}
+
+// validateSort checks the sort mode is known, empty is sortNone.
+func validateSort(mode string) error {
+	switch mode {
+	case "", sortNone, sortMemory, sortExternal:
+		return nil
+	}
+	return fmt.Errorf("%w %q, use one of: %s, %s, %s", ErrUnknownSort, mode, sortNone, sortMemory, sortExternal)
+}
+
+// sortSource returns src sorted by timestamp according to mode.
+// Events with the same timestamp keep their input order.
+// With sortExternal the returned source is an io.Closer, it must be closed to remove temp files.
+func sortSource(src eventSource, mode string, runSize int, dir string) (eventSource, error) {
+	switch mode {
+	case "", sortNone:
+		return src, nil
+	case sortMemory:
+		events, _, err := readRun(src, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &sliceSource{events: events}, nil
+	case sortExternal:
+		return newMergeSource(src, runSize, dir)
+	}
+	return nil, validateSort(mode)
+}
+
+// readRun reads up to max events(no limit when max is 0) from src and sorts them.
+// more is false once src is done.
+func readRun(src eventSource, max int) (events []event, more bool, err error) {
+	for max == 0 || len(events) < max {
+		e, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		events = append(events, e)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp.Time)
+	})
+	return events, max > 0 && len(events) == max, nil
+}
+
+// run is a sorted chunk of events spilled to a temp file with gob.
+type run struct {
+	index int
+	file  *os.File
+	dec   *gob.Decoder
+	head  event
+}
+
+// next decodes the run head, it returns io.EOF once the run is done.
+func (r *run) next() error {
+	r.head = event{}
+	return r.dec.Decode(&r.head)
+}
+
+// runHeap orders runs by their head timestamp, runs read earlier win ties so sorting is stable.
+type runHeap []*run
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if !h[i].head.Timestamp.Equal(h[j].head.Timestamp.Time) {
+		return h[i].head.Timestamp.Before(h[j].head.Timestamp.Time)
+	}
+	return h[i].index < h[j].index
+}
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*run)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeSource is an external merge sort: src is split in sorted runs of runSize events
+// written to temp files in dir, which are then k-way merged as events are read.
+// Memory is one run while spilling and one event per run while merging.
+type mergeSource struct {
+	// dir is where runs are written, os.TempDir() when empty.
+	dir   string
+	files []*os.File
+	runs  runHeap
+}
+
+// newMergeSource reads the whole src, spilling sorted runs to temp files.
+func newMergeSource(src eventSource, runSize int, dir string) (*mergeSource, error) {
+	if runSize <= 0 {
+		runSize = defaultSortRun
+	}
+	m := &mergeSource{dir: dir}
+	for more := true; more; {
+		events, ok, err := readRun(src, runSize)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		more = ok
+		if len(events) == 0 {
+			break
+		}
+		if err := m.spill(events); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+
+	for i, f := range m.files {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			m.Close()
+			return nil, err
+		}
+		r := &run{index: i, file: f, dec: gob.NewDecoder(bufio.NewReader(f))}
+		if err := r.next(); err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.runs = append(m.runs, r)
+	}
+	heap.Init(&m.runs)
+	return m, nil
+}
+
+// spill writes a sorted run to a new temp file.
+func (m *mergeSource) spill(events []event) error {
+	f, err := os.CreateTemp(m.dir, "sma-sort-*.run")
+	if err != nil {
+		return err
+	}
+	m.files = append(m.files, f)
+
+	bw := bufio.NewWriter(f)
+	enc := gob.NewEncoder(bw)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Next returns the smallest head among runs.
+func (m *mergeSource) Next() (event, error) {
+	if len(m.runs) == 0 {
+		return event{}, io.EOF
+	}
+	r := m.runs[0]
+	e := r.head
+	err := r.next()
+	switch {
+	case err == io.EOF:
+		heap.Remove(&m.runs, 0)
+	case err != nil:
+		return event{}, err
+	default:
+		heap.Fix(&m.runs, 0)
+	}
+	return e, nil
+}
+
+// Close removes the temp files.
+func (m *mergeSource) Close() error {
+	var errs []error
+	for _, f := range m.files {
+		errs = append(errs, f.Close(), os.Remove(f.Name()))
+	}
+	m.files, m.runs = nil, nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// shuffledEvents returns events.json with its last event moved first.
+func shuffledEvents(t *testing.T) []event {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+	return append([]event{events[len(events)-1]}, events[:len(events)-1]...)
+}
+
+func TestUnsortedInput(t *testing.T) {
+	events := shuffledEvents(t)
+
+	t.Run("when slice is unsorted should report the first violation", func(t *testing.T) {
+		for _, fn := range []func([]event, int32, sink) error{SMA, FIFOSMA, FIFOSMAMinified, BuffFIFOSMA} {
+			err := fn(events, 10, funcSink(func(output) error { return nil }))
+			require.ErrorIs(t, err, ErrUnsortedInput)
+			require.EqualError(t, err, ErrUnsortedInput.Error()+
+				": event 2 at 2018-12-26 18:11:08.509654 is before the previous one at 2018-12-26 18:23:19.903159")
+		}
+	})
+
+	t.Run("when source is unsorted should stop streaming", func(t *testing.T) {
+		_, err := collectSMA(&sliceSource{events: events}, engines["fifo"], smaOptions{Window: 10 * time.Minute})
+		var unsorted *unsortedError
+		require.ErrorAs(t, err, &unsorted)
+		require.Equal(t, 2, unsorted.index)
+	})
+
+	t.Run("when events are unsorted within a bucket should report the first violation", func(t *testing.T) {
+		base := time.Date(2018, 12, 26, 18, 11, 0, 0, time.UTC)
+		inBucket := []event{
+			{Timestamp: customTime{base.Add(30 * time.Second)}, Duration: 1},
+			{Timestamp: customTime{base.Add(10 * time.Second)}, Duration: 2},
+		}
+		_, err := collectSMA(&sliceSource{events: inBucket}, engines["fifo"], smaOptions{Window: 10 * time.Minute})
+		var unsorted *unsortedError
+		require.ErrorAs(t, err, &unsorted)
+		require.Equal(t, 2, unsorted.index)
+
+		// allowed lateness sorts them back instead.
+		_, err = collectSMA(&sliceSource{events: inBucket}, engines["fifo"], smaOptions{Window: 10 * time.Minute, AllowedLateness: time.Minute})
+		require.NoError(t, err)
+	})
+
+	t.Run("when sort mode is unknown should error", func(t *testing.T) {
+		require.ErrorIs(t, validateSort("quick"), ErrUnknownSort)
+	})
+}
+
+func TestSortSource(t *testing.T) {
+	// events with repeated timestamps, ids tell their input order apart.
+	var events []event
+	base := time.Date(2018, 12, 26, 18, 11, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		events = append(events, event{
+			Timestamp:     customTime{base.Add(time.Duration(rand.Intn(10)) * time.Second)},
+			TranslationID: fmt.Sprint(i),
+			Duration:      i,
+		})
+	}
+	want := append([]event(nil), events...)
+	sort.SliceStable(want, func(i, j int) bool { return want[i].Timestamp.Before(want[j].Timestamp.Time) })
+
+	tcs := []struct {
+		name    string
+		mode    string
+		runSize int
+	}{
+		{name: "when sorting in memory should sort stable", mode: sortMemory},
+		{name: "when sorting external should merge runs stable", mode: sortExternal, runSize: 7},
+		{name: "when sorting external in a single run should sort stable", mode: sortExternal, runSize: 100},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src, err := sortSource(&sliceSource{events: events}, tc.mode, tc.runSize, dir)
+			require.NoError(t, err)
+
+			var got []event
+			for {
+				e, err := src.Next()
+				if err != nil {
+					break
+				}
+				got = append(got, e)
+			}
+			require.Len(t, got, len(want))
+			for i := range want {
+				require.Equal(t, want[i].TranslationID, got[i].TranslationID)
+				require.True(t, want[i].Timestamp.Equal(got[i].Timestamp.Time))
+			}
+
+			if c, ok := src.(interface{ Close() error }); ok {
+				require.NoError(t, c.Close())
+			}
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			require.Empty(t, entries)
+		})
+	}
+}
+
+func TestRootSort(t *testing.T) {
+	input, err := os.ReadFile("./testInput.json")
+	require.NoError(t, err)
+	var raw []json.RawMessage
+	require.NoError(t, json.Unmarshal(input, &raw))
+	// move the first event to the end, one event per line.
+	var shuffled []byte
+	for _, e := range append(raw[1:], raw[0]) {
+		shuffled = append(append(shuffled, e...), '\n')
+	}
+
+	defer func() {
+		rootCmd.SetIn(nil)
+		rootCmd.SetOut(nil)
+		require.NoError(t, rootCmd.Flags().Set(INPUT_FILE_FLAG, "../events.json"))
+		require.NoError(t, rootCmd.Flags().Set(OUTPUT_FLAG, "./result.txt"))
+		require.NoError(t, rootCmd.Flags().Set(SORT_FLAG, sortNone))
+		require.NoError(t, rootCmd.Flags().Set(SORT_RUN_FLAG, fmt.Sprint(defaultSortRun)))
+		require.NoError(t, rootCmd.Flags().Set(SORT_DIR_FLAG, ""))
+	}()
+
+	tcs := []struct {
+		name    string
+		args    []string
+		wantErr error
+	}{
+		{name: "when input is unsorted should error", args: []string{}, wantErr: ErrUnsortedInput},
+		{name: "when sorting in memory should create result output", args: []string{"--sort=memory"}},
+		{name: "when sorting external should create result output", args: []string{"--sort=external", "--sort-run=1", "--sort-dir=" + t.TempDir()}},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			rootCmd.SetIn(bytes.NewReader(shuffled))
+			rootCmd.SetOut(&stdout)
+			rootCmd.SetArgs(append([]string{"--input_file=-", "--output=-", "--window_size=10", "--sort=none"}, tc.args...))
+
+			err := rootCmd.Execute()
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			want, err := os.ReadFile("./testResult.txt")
+			require.NoError(t, err)
+			require.Equal(t, string(want), stdout.String())
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var ErrUnknownStat = errors.New("unknown stat")
+
+// statNames are the statistics available with --stats, in the order they show up in each row.
+// avg is also accepted, it's the average_delivery_time every row already has.
+var statNames = []string{"count", "sum", "min", "max", "stddev", "nr_words"}
+
+// parseStats validates the given stats and returns them in statNames order.
+func parseStats(names []string) ([]string, error) {
+	asked := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "avg" {
+			continue
+		}
+		if !containsString(statNames, name) {
+			return nil, fmt.Errorf("%w %q, use any of: avg, %s", ErrUnknownStat, name, strings.Join(statNames, ", "))
+		}
+		asked[name] = true
+	}
+
+	var stats []string
+	for _, name := range statNames {
+		if asked[name] {
+			stats = append(stats, name)
+		}
+	}
+	return stats, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// windowStats calculates --stats of a group window.
+// count, sum, stddev and nr_words come from the running aggregates of the window queue,
+// while min and max have their own monotonic deques so we don't look for them in
+// the whole window every minute.
+type windowStats struct {
+	stats []string
+	min   *monoDeque
+	max   *monoDeque
+	// enqueued and evicted count events, which is how deque items are matched to
+	// evicted events, since events leave the window in the same order they entered it.
+	enqueued int
+	evicted  int
+}
+
+// newWindowStats creates a windowStats for the given, already parsed, stats.
+func newWindowStats(stats []string) *windowStats {
+	return &windowStats{
+		stats: stats,
+		min:   &monoDeque{keep: func(back, v float64) bool { return back < v }},
+		max:   &monoDeque{keep: func(back, v float64) bool { return back > v }},
+	}
+}
+
+// add is called for every enqueued event.
+func (s *windowStats) add(e event) {
+	v := float64(e.Duration)
+	s.min.push(s.enqueued, v)
+	s.max.push(s.enqueued, v)
+	s.enqueued++
+}
+
+// remove is called for every evicted event.
+func (s *windowStats) remove(e event) {
+	s.evicted++
+	s.min.evict(s.evicted)
+	s.max.evict(s.evicted)
+}
+
+// fields returns the stats of the window held by queue.
+func (s *windowStats) fields(queue windowQueue) []field {
+	agg := queue.Aggregates()
+	count := float64(agg.count)
+	sum := agg.sum.value()
+	sumSquares := agg.sumSquares.value()
+	words := agg.words.value()
+
+	fields := make([]field, 0, len(s.stats))
+	for _, name := range s.stats {
+		var value any
+		switch name {
+		case "count":
+			value = int64(count)
+		case "sum":
+			value = int64(math.Round(sum))
+		case "min":
+			value = int64(s.min.front())
+		case "max":
+			value = int64(s.max.front())
+		case "stddev":
+			value = float32(stddev(count, sum, sumSquares))
+		case "nr_words":
+			value = int64(math.Round(words))
+		}
+		fields = append(fields, field{Name: name, Value: value})
+	}
+	return fields
+}
+
+// stddev is the population standard deviation from count, sum and sum of squares.
+func stddev(count, sum, sumSquares float64) float64 {
+	if count == 0 {
+		return 0
+	}
+	mean := sum / count
+	variance := sumSquares/count - mean*mean
+	// float rounding can make it slightly negative.
+	if variance < 0 {
+		return 0
+	}
+	return math.Sqrt(variance)
+}
+
+// monoDeque is a monotonic deque for sliding window min/max: values that can
+// never be the min(or max) again, because a better and newer one was pushed,
+// are dropped, so front is always the answer and each value is pushed and popped once.
+type monoDeque struct {
+	items []dequeItem
+	// keep tells if the back item stays when v is pushed.
+	keep func(back, v float64) bool
+}
+
+type dequeItem struct {
+	seq   int
+	value float64
+}
+
+func (d *monoDeque) push(seq int, v float64) {
+	for len(d.items) > 0 && !d.keep(d.items[len(d.items)-1].value, v) {
+		d.items = d.items[:len(d.items)-1]
+	}
+	d.items = append(d.items, dequeItem{seq: seq, value: v})
+}
+
+// evict drops items of events with seq before the given one, they left the window.
+func (d *monoDeque) evict(seq int) {
+	for len(d.items) > 0 && d.items[0].seq < seq {
+		d.items = d.items[1:]
+	}
+}
+
+// front returns the min(or max) of the window, 0 when empty like the avg.
+func (d *monoDeque) front() float64 {
+	if len(d.items) == 0 {
+		return 0
+	}
+	return d.items[0].value
+}
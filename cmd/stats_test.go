@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStats(t *testing.T) {
+	got, err := parseStats([]string{"max", "avg", "count", "min"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"count", "min", "max"}, got)
+
+	_, err = parseStats([]string{"median"})
+	require.ErrorIs(t, err, ErrUnknownStat)
+}
+
+func TestMonoDeque(t *testing.T) {
+	d := newWindowStats(nil).min
+	values := []float64{5, 3, 4, 1, 2}
+	for i, v := range values {
+		d.push(i, v)
+	}
+	require.Equal(t, float64(1), d.front())
+
+	// evict the first 4 values, only 2 is left in the window.
+	d.evict(4)
+	require.Equal(t, float64(2), d.front())
+
+	d.evict(5)
+	require.Equal(t, float64(0), d.front())
+}
+
+func TestStreamSMAStats(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+	at := func(s string) resultKey {
+		date, err := time.Parse("2006-01-02 15:04:05", s)
+		require.NoError(t, err)
+		return resultKey{Date: date}
+	}
+
+	for name := range engines {
+		name := name
+		t.Run("when engine "+name+" should calculate stats", func(t *testing.T) {
+			got, err := collectSMA(&sliceSource{events: events}, engines[name], smaOptions{
+				Window: 10 * time.Minute,
+				Stats:  []string{"count", "avg", "sum", "min", "max", "stddev", "nr_words"},
+			})
+			require.NoError(t, err)
+
+			require.Equal(t, []field{
+				{Name: "count", Value: int64(0)},
+				{Name: "sum", Value: int64(0)},
+				{Name: "min", Value: int64(0)},
+				{Name: "max", Value: int64(0)},
+				{Name: "stddev", Value: float32(0)},
+				{Name: "nr_words", Value: int64(0)},
+			}, got[at("2018-12-26 18:11:00")].Fields)
+
+			require.Equal(t, []field{
+				{Name: "count", Value: int64(2)},
+				{Name: "sum", Value: int64(51)},
+				{Name: "min", Value: int64(20)},
+				{Name: "max", Value: int64(31)},
+				{Name: "stddev", Value: float32(5.5)},
+				{Name: "nr_words", Value: int64(60)},
+			}, got[at("2018-12-26 18:20:00")].Fields)
+
+			// the 18:11 event left the window.
+			require.Equal(t, []field{
+				{Name: "count", Value: int64(2)},
+				{Name: "sum", Value: int64(85)},
+				{Name: "min", Value: int64(31)},
+				{Name: "max", Value: int64(54)},
+				{Name: "stddev", Value: float32(11.5)},
+				{Name: "nr_words", Value: int64(130)},
+			}, got[at("2018-12-26 18:24:00")].Fields)
+		})
+	}
+}
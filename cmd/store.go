@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// eventStore keeps the most recent events pushed to the server, sorted by timestamp,
+// so any window asked for can be calculated on demand.
+// Memory is bounded: events older than retention behind the most recent one are
+// evicted, and so are the oldest ones once there are more than max.
+type eventStore struct {
+	mu        sync.RWMutex
+	events    []event
+	max       int
+	retention time.Duration
+	// evicted counts events evicted to keep the store bounded.
+	evicted int
+}
+
+// newEventStore creates an eventStore keeping up to max events within retention.
+func newEventStore(max int, retention time.Duration) *eventStore {
+	return &eventStore{max: max, retention: retention}
+}
+
+// add inserts events in timestamp order, they usually go to the end.
+// Events with the same timestamp keep their arrival order.
+func (s *eventStore) add(events ...event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range events {
+		n := len(s.events)
+		if n == 0 || !e.Timestamp.Before(s.events[n-1].Timestamp.Time) {
+			s.events = append(s.events, e)
+			continue
+		}
+		i := sort.Search(n, func(i int) bool { return s.events[i].Timestamp.After(e.Timestamp.Time) })
+		s.events = append(s.events, event{})
+		copy(s.events[i+1:], s.events[i:])
+		s.events[i] = e
+	}
+	s.evict()
+}
+
+// evict drops events out of retention, then the oldest ones over max.
+func (s *eventStore) evict() {
+	if len(s.events) == 0 {
+		return
+	}
+	oldest := s.events[len(s.events)-1].Timestamp.Add(-s.retention)
+	i := sort.Search(len(s.events), func(i int) bool { return !s.events[i].Timestamp.Before(oldest) })
+	if over := len(s.events) - s.max; s.max > 0 && over > i {
+		i = over
+	}
+	if i == 0 {
+		return
+	}
+	s.evicted += i
+	// after a large eviction survivors are copied, the evicted events would stay in the
+	// backing array until an append grows it.
+	if i > len(s.events)-i {
+		s.events = append([]event(nil), s.events[i:]...)
+		return
+	}
+	s.events = s.events[i:]
+}
+
+// snapshot returns a copy of the stored events, safe to read while more events are added.
+func (s *eventStore) snapshot() []event {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]event(nil), s.events...)
+}
+
+// since returns a copy of the stored events at or after t.
+func (s *eventStore) since(t time.Time) []event {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := sort.Search(len(s.events), func(i int) bool { return !s.events[i].Timestamp.Before(t) })
+	return append([]event(nil), s.events[i:]...)
+}
+
+// latest returns the timestamp of the most recent event, zero when there are no events.
+func (s *eventStore) latest() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.events) == 0 {
+		return time.Time{}
+	}
+	return s.events[len(s.events)-1].Timestamp.Time
+}
+
+// counts returns how many events are stored and how many were evicted.
+func (s *eventStore) counts() (stored, evicted int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.events), s.evicted
+}
+
+// len returns how many events are stored.
+func (s *eventStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.events)
+}
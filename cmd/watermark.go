@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var ErrUnknownLate = errors.New("unknown late mode")
+var ErrInvalidLateness = errors.New("allowed lateness can't be negative")
+var ErrLateCorrection = errors.New("late corrections need a metric of the window events only, ema isn't one")
+
+// --late modes, they tell what to do with events arriving after their rows were written.
+const (
+	// lateFail stops with an unsortedError, the default since batch input should be sorted.
+	lateFail = "fail"
+	// lateDrop leaves late events out of the sma, they are handed to smaOptions.OnLate.
+	lateDrop = "drop"
+	// lateCorrect writes correction rows for the rows that should have had the late event.
+	// Events older than one window before the last written row are handled like lateDrop.
+	lateCorrect = "correct"
+)
+
+// validateLate checks the --late mode and allowed lateness of opts.
+func validateLate(opts smaOptions) error {
+	if opts.AllowedLateness < 0 {
+		return ErrInvalidLateness
+	}
+	switch opts.Late {
+	case "", lateFail, lateDrop:
+		return nil
+	case lateCorrect:
+		// ema depends on every bucket since the first one, not only on the window.
+		if opts.Metric == "ema" {
+			return ErrLateCorrection
+		}
+		return nil
+	}
+	return fmt.Errorf("%w %q, use one of: %s, %s, %s", ErrUnknownLate, opts.Late, lateFail, lateDrop, lateCorrect)
+}
+
+// streamer is the StreamSMA loop turned into a state machine driven by arriving events,
+// so it works for a live feed where we can't wait for the whole input.
+// Events wait in pending until the watermark, the most recent timestamp seen minus
+// opts.AllowedLateness, passes their bucket. A bucket is finalized, and its rows emitted,
+// once the watermark reaches it, so events up to AllowedLateness out of order are
+// still in the right rows. Later events are handled according to opts.Late.
+type streamer struct {
+	opts   smaOptions
+	out    sink
+	groups *groupSet
+	// pending are events waiting for the watermark, sorted by timestamp.
+	pending []event
+	// maxTs is the most recent event timestamp seen.
+	maxTs time.Time
+	// started is set with the first finalized bucket, currBucket is the next one to finalize.
+	started    bool
+	currBucket time.Time
+	// lastTs is the timestamp of the last event enqueued in a group.
+	lastTs time.Time
+	// read counts pushed events, prevTs is the timestamp of the previous one.
+	read   int
+	prevTs time.Time
+}
+
+// newStreamerFor checks opts and creates a streamer with the metric, quantiles and stats they ask for.
+func newStreamerFor(newQueue func() windowQueue, opts smaOptions, out sink) (*streamer, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	newMetric, err := metricFactory(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var newQuantiles func() quantileWindow
+	if len(opts.Quantiles) > 0 {
+		if err := validateQuantiles(opts.Quantiles); err != nil {
+			return nil, err
+		}
+		newQuantiles, err = quantileWindowFactory(opts.QuantileMode, opts.QuantileAccuracy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opts.Stats, err = parseStats(opts.Stats)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := outputColumns(opts); err != nil {
+		return nil, err
+	}
+	return newStreamer(newQueue, newMetric, newQuantiles, opts, out), nil
+}
+
+// newStreamer creates a streamer emitting rows to out, opts must already have defaults.
+func newStreamer(newQueue func() windowQueue, newMetric func() metric, newQuantiles func() quantileWindow, opts smaOptions, out sink) *streamer {
+	return &streamer{
+		opts:   opts,
+		out:    out,
+		groups: newGroupSet(opts, newQueue, newMetric, newQuantiles),
+	}
+}
+
+// Push handles an arriving event and emits the rows of every bucket the watermark passed.
+func (s *streamer) Push(e event) error {
+	s.read++
+	prevTs := s.prevTs
+	s.prevTs = e.Timestamp.Time
+
+	// with no lateness allowed the input must be sorted, the first event before the previous
+	// one is reported even when its bucket is still open.
+	if s.opts.AllowedLateness == 0 && (s.opts.Late == "" || s.opts.Late == lateFail) && e.Timestamp.Before(prevTs) {
+		return &unsortedError{index: s.read, ts: e.Timestamp.Time, prev: prevTs}
+	}
+
+	// rows up to the bucket before currBucket were written, the event should have been in them.
+	if s.started && e.Timestamp.Before(s.currBucket.Add(-s.opts.Step)) {
+		return s.late(e, prevTs)
+	}
+
+	// insert after events with the same timestamp, so they keep their arrival order.
+	i := sort.Search(len(s.pending), func(i int) bool { return s.pending[i].Timestamp.After(e.Timestamp.Time) })
+	s.pending = append(s.pending, event{})
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = e
+
+	if e.Timestamp.After(s.maxTs) {
+		s.maxTs = e.Timestamp.Time
+	}
+	return s.advance(false)
+}
+
+// Close finalizes every bucket left, like the watermark went to the end of time.
+func (s *streamer) Close() error {
+	return s.advance(true)
+}
+
+// advance finalizes buckets while the watermark is past them. With final it keeps going
+// until last event bucket + 1 step, like FIFOSMA does.
+func (s *streamer) advance(final bool) error {
+	watermark := s.maxTs.Add(-s.opts.AllowedLateness)
+	for {
+		if !s.started {
+			if len(s.pending) == 0 {
+				return nil
+			}
+			first := bucket(s.pending[0].Timestamp.Time, s.opts.Step)
+			if !final && watermark.Before(first) {
+				return nil
+			}
+			s.currBucket, s.started = first, true
+		}
+
+		if final {
+			end := bucket(s.lastTs, s.opts.Step).Add(s.opts.Step)
+			if len(s.pending) == 0 && s.currBucket.After(end) {
+				return nil
+			}
+		} else if watermark.Before(s.currBucket) {
+			return nil
+		}
+
+		if err := s.finalize(watermark, final); err != nil {
+			return err
+		}
+	}
+}
+
+// finalize enqueues pending events before currBucket, emits the rows of every group
+// and moves on to the next bucket.
+func (s *streamer) finalize(watermark time.Time, final bool) error {
+	currBucket := s.currBucket
+	for len(s.pending) > 0 && s.pending[0].Timestamp.Before(currBucket) {
+		e := s.pending[0]
+		s.pending = s.pending[1:]
+		s.groups.get(e).enqueue(e)
+		s.lastTs = e.Timestamp.Time
+	}
+	// the group of the next event shows up before its events are enqueued,
+	// the same way it does when pulling events one ahead.
+	var next *group
+	if len(s.pending) > 0 {
+		next = s.groups.get(s.pending[0])
+		// it's enqueued with the next bucket, no need to drop it in between.
+		if bucket(s.pending[0].Timestamp.Time, s.opts.Step).After(currBucket) {
+			next = nil
+		}
+	}
+
+	drained := true
+	for _, g := range s.groups.sorted {
+		if currBucket.Before(g.start) {
+			continue
+		}
+		if row, ok := g.gapRow(g.row(currBucket)); ok {
+			if err := s.emit(row); err != nil {
+				return err
+			}
+		}
+		drained = drained && g.empty()
+		if s.opts.Late == lateCorrect {
+			g.trimHistory(currBucket)
+		}
+	}
+	if s.opts.DropDrained {
+		for _, g := range s.groups.dropDrained(currBucket, next) {
+			if s.opts.OnDrop != nil {
+				s.opts.OnDrop(g.labels)
+			}
+		}
+	}
+	s.currBucket = currBucket.Add(s.opts.Step)
+
+	// all windows are empty until the next event, no need to step through every bucket.
+	if drained && len(s.pending) > 0 && gapsJump(s.opts.Gaps) {
+		next := bucket(s.pending[0].Timestamp.Time, s.opts.Step)
+		// buckets after the watermark can still get events, we can't skip them yet.
+		if limit := bucket(watermark, s.opts.Step).Add(s.opts.Step); !final && next.After(limit) {
+			next = limit
+		}
+		if next.After(s.currBucket) {
+			s.currBucket = next
+		}
+	}
+	return nil
+}
+
+// late handles an event arriving after its rows were written.
+func (s *streamer) late(e event, prevTs time.Time) error {
+	switch s.opts.Late {
+	case lateDrop:
+	case lateCorrect:
+		lastRow := s.currBucket.Add(-s.opts.Step)
+		if e.Timestamp.After(lastRow.Add(-s.opts.Window)) {
+			return s.correct(e, lastRow)
+		}
+	default:
+		return &unsortedError{index: s.read, ts: e.Timestamp.Time, prev: prevTs}
+	}
+
+	if s.opts.OnLate != nil {
+		return s.opts.OnLate(e)
+	}
+	return nil
+}
+
+// correct adds a late event to its group and emits correction rows, from the first
+// bucket having the event up to lastRow. The group window state is rebuilt from its
+// history, as if the event had arrived in time.
+// Rows go through --gaps like the others, the gap state starts over from the first one.
+func (s *streamer) correct(e event, lastRow time.Time) error {
+	g := s.groups.get(e)
+	first := bucket(e.Timestamp.Time, s.opts.Step)
+	// a group new with the event also has the row of its start bucket, an empty one,
+	// the one after has the event in its window.
+	if !g.start.Equal(first) || len(g.history) > 0 {
+		first = first.Add(s.opts.Step)
+	}
+
+	i := sort.Search(len(g.history), func(i int) bool { return g.history[i].Timestamp.After(e.Timestamp.Time) })
+	history := make([]event, 0, len(g.history)+1)
+	history = append(append(append(history, g.history[:i]...), e), g.history[i:]...)
+
+	rebuilt := s.groups.newGroup(g.key, g.labels, g.start)
+	next := 0
+	for b := first; !b.After(lastRow); b = b.Add(s.opts.Step) {
+		for next < len(history) && history[next].Timestamp.Before(b) {
+			rebuilt.enqueue(history[next])
+			next++
+		}
+		row, ok := rebuilt.gapRow(rebuilt.row(b))
+		if !ok {
+			continue
+		}
+		row.correction = true
+		if err := s.emit(row); err != nil {
+			return err
+		}
+	}
+
+	g.queue, g.metric, g.quantiles, g.stats, g.history = rebuilt.queue, rebuilt.metric, rebuilt.quantiles, rebuilt.stats, rebuilt.history
+	g.lastRow, g.inGap = rebuilt.lastRow, rebuilt.inGap
+	return nil
+}
+
+// emit sends a row to the output sink.
+func (s *streamer) emit(row output) error {
+	if err := s.out.Emit(row); err != nil {
+		return fmt.Errorf("%w: %v", ErrWriteOutput, err)
+	}
+	return nil
+}
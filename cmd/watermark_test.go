@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStreamer creates a fifo streamer collecting rows in got.
+func newTestStreamer(t *testing.T, opts smaOptions, got *[]output) *streamer {
+	opts = opts.withDefaults()
+	require.NoError(t, opts.validate())
+	newMetric, err := metricFactory(opts)
+	require.NoError(t, err)
+	return newStreamer(engines["fifo"], newMetric, nil, opts, funcSink(func(row output) error {
+		*got = append(*got, row)
+		return nil
+	}))
+}
+
+func TestStreamerWatermark(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+	want := createWantOutput()
+
+	t.Run("when events arrive should only emit buckets the watermark passed", func(t *testing.T) {
+		var got []output
+		s := newTestStreamer(t, smaOptions{Window: 10 * time.Minute, AllowedLateness: 2 * time.Minute}, &got)
+
+		// watermark is 18:09:08, before the first bucket.
+		require.NoError(t, s.Push(events[0]))
+		require.Empty(t, got)
+
+		// watermark is 18:13:19, 18:11 to 18:13 are done.
+		require.NoError(t, s.Push(events[1]))
+		require.Len(t, got, 3)
+
+		// watermark is 18:21:19.
+		require.NoError(t, s.Push(events[2]))
+		require.Len(t, got, 11)
+
+		require.NoError(t, s.Close())
+		require.Len(t, got, 14)
+		for _, row := range got {
+			require.Equal(t, want[row.Date], row)
+		}
+	})
+
+	t.Run("when events are out of order within lateness should sort them back", func(t *testing.T) {
+		var got []output
+		s := newTestStreamer(t, smaOptions{Window: 10 * time.Minute, AllowedLateness: 10 * time.Minute}, &got)
+		for _, i := range []int{1, 0, 2} {
+			require.NoError(t, s.Push(events[i]))
+		}
+		require.NoError(t, s.Close())
+
+		require.Len(t, got, 14)
+		for _, row := range got {
+			require.Equal(t, want[row.Date], row)
+		}
+	})
+}
+
+func TestStreamerLateEvents(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+	want := createWantOutput()
+	// 18:15:19 arrives after 18:23:19, rows up to 18:23 are written by then.
+	arrivals := []event{events[0], events[2], events[1]}
+
+	t.Run("when late mode is fail should error", func(t *testing.T) {
+		var got []output
+		s := newTestStreamer(t, smaOptions{Window: 10 * time.Minute}, &got)
+		require.NoError(t, s.Push(arrivals[0]))
+		require.NoError(t, s.Push(arrivals[1]))
+		require.ErrorIs(t, s.Push(arrivals[2]), ErrUnsortedInput)
+	})
+
+	t.Run("when late mode is drop should leave late events out", func(t *testing.T) {
+		var got []output
+		var late []event
+		s := newTestStreamer(t, smaOptions{
+			Window: 10 * time.Minute,
+			Late:   lateDrop,
+			OnLate: func(e event) error {
+				late = append(late, e)
+				return nil
+			},
+		}, &got)
+		for _, e := range arrivals {
+			require.NoError(t, s.Push(e))
+		}
+		require.NoError(t, s.Close())
+
+		require.Equal(t, []event{events[1]}, late)
+		require.Len(t, got, 14)
+		require.Equal(t, float32(54), got[len(got)-1].AvgDeliveryTime, "18:24 has 18:15 left out")
+	})
+
+	t.Run("when late mode is correct should emit correction rows", func(t *testing.T) {
+		var got []output
+		s := newTestStreamer(t, smaOptions{Window: 10 * time.Minute, Late: lateCorrect}, &got)
+		for _, e := range arrivals {
+			require.NoError(t, s.Push(e))
+		}
+		require.NoError(t, s.Close())
+
+		// 13 rows, 8 corrections from 18:16 to 18:23, and 18:24.
+		require.Len(t, got, 13+8+1)
+		corrections := got[13 : 13+8]
+		for _, row := range corrections {
+			require.True(t, row.correction)
+			row.correction = false
+			require.Equal(t, want[row.Date], row)
+		}
+		require.Equal(t, want[got[len(got)-1].Date], got[len(got)-1], "18:24 has 18:15 in")
+
+		bs, err := json.Marshal(corrections[0])
+		require.NoError(t, err)
+		require.Equal(t, `{"date":"2018-12-26 18:16:00","average_delivery_time":25.5,"correction":true}`, string(bs))
+	})
+
+	t.Run("when late event is older than a window should not correct", func(t *testing.T) {
+		var got []output
+		var late []event
+		s := newTestStreamer(t, smaOptions{
+			Window: 5 * time.Minute,
+			Late:   lateCorrect,
+			OnLate: func(e event) error {
+				late = append(late, e)
+				return nil
+			},
+		}, &got)
+		for _, e := range arrivals {
+			require.NoError(t, s.Push(e))
+		}
+		require.Equal(t, []event{events[1]}, late)
+	})
+}
+
+func TestStreamerLateGaps(t *testing.T) {
+	var events []event
+	for _, line := range []string{
+		`{"timestamp":"2018-12-26 18:11:08","client_name":"airliberty","duration":20}`,
+		`{"timestamp":"2018-12-26 18:15:19","client_name":"airliberty","duration":31}`,
+		// flyhigh is late, and its first event.
+		`{"timestamp":"2018-12-26 18:20:30","client_name":"flyhigh","duration":12}`,
+		`{"timestamp":"2018-12-26 18:23:19","client_name":"taxi-eats","duration":54}`,
+		`{"timestamp":"2018-12-26 18:40:00","client_name":"taxi-eats","duration":10}`,
+	} {
+		var e event
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+		events = append(events, e)
+	}
+	arrivals := []event{events[0], events[1], events[3], events[2], events[4]}
+
+	// rows of each bucket and group, corrections replacing the rows they correct.
+	final := func(rows []output) map[string]output {
+		got := make(map[string]output)
+		for _, row := range rows {
+			row.correction = false
+			got[row.Date.Format("15:04")+" "+labelsKey(row.Labels)] = row
+		}
+		return got
+	}
+
+	for _, gaps := range []string{gapsZero, gapsCarry, gapsSkip, gapsNull} {
+		gaps := gaps
+		t.Run("when gaps is "+gaps+" should correct to the rows of sorted events", func(t *testing.T) {
+			opts := smaOptions{Window: 10 * time.Minute, GroupBy: []string{"client_name"}, Gaps: gaps, Late: lateCorrect}
+			var sorted, got []output
+			s := newTestStreamer(t, opts, &sorted)
+			for _, e := range events {
+				require.NoError(t, s.Push(e))
+			}
+			require.NoError(t, s.Close())
+
+			s = newTestStreamer(t, opts, &got)
+			for _, e := range arrivals {
+				require.NoError(t, s.Push(e))
+			}
+			require.NoError(t, s.Close())
+
+			require.Equal(t, final(sorted), final(got))
+		})
+	}
+}
+
+func TestStreamerDropDrained(t *testing.T) {
+	events, err := parseInputFile("../events.json")
+	require.NoError(t, err)
+	// taxi-eats is back after both windows drained.
+	var back event
+	require.NoError(t, json.Unmarshal([]byte(`{"timestamp":"2018-12-26 18:40:00","client_name":"taxi-eats","duration":10}`), &back))
+	events = append(events, back)
+	opts := smaOptions{Window: 10 * time.Minute, GroupBy: []string{"client_name"}}
+
+	var all []output
+	s := newTestStreamer(t, opts, &all)
+	for _, e := range events {
+		require.NoError(t, s.Push(e))
+	}
+	require.NoError(t, s.Close())
+
+	var got []output
+	var dropped []string
+	opts.DropDrained = true
+	opts.OnDrop = func(labels []label) {
+		dropped = append(dropped, labels[0].Value)
+	}
+	s = newTestStreamer(t, opts, &got)
+	for _, e := range events {
+		require.NoError(t, s.Push(e))
+	}
+	require.NoError(t, s.Close())
+
+	// a drained group gets a last empty row, and no more until its next event.
+	var want []output
+	for _, row := range all {
+		client := row.Labels[0].Value
+		at := row.Date.Format("15:04")
+		if (client == "airliberty" && at > "18:26") || (client == "taxi-eats" && at > "18:34" && at < "18:40") {
+			continue
+		}
+		want = append(want, row)
+	}
+	require.Equal(t, want, got)
+	require.Equal(t, []string{"airliberty", "taxi-eats"}, dropped)
+	require.Len(t, s.groups.sorted, 1)
+	require.Len(t, s.groups.byKey, 1)
+}
+
+func TestValidateLate(t *testing.T) {
+	tcs := []struct {
+		name    string
+		opts    smaOptions
+		wantErr error
+	}{
+		{name: "when late mode is empty should not error", opts: smaOptions{}},
+		{name: "when late mode is correct should not error", opts: smaOptions{Late: lateCorrect, Metric: "wma"}},
+		{name: "when late mode is unknown should error", opts: smaOptions{Late: "ignore"}, wantErr: ErrUnknownLate},
+		{name: "when lateness is negative should error", opts: smaOptions{AllowedLateness: -time.Second}, wantErr: ErrInvalidLateness},
+		{name: "when correcting ema should error", opts: smaOptions{Late: lateCorrect, Metric: "ema"}, wantErr: ErrLateCorrection},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateLate(tc.opts)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
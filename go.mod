@@ -1,8 +1,9 @@
 module github.com/dibrito/backend-engineering-challenge
 
-go 1.21.3
+go 1.22
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/spf13/cobra v1.8.0
 	github.com/stretchr/testify v1.9.0
 )
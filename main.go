@@ -8,10 +8,10 @@ import (
 )
 
 func main() {
+	// we only talk to stderr, stdout might be carrying the results.
 	if err := cmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	fmt.Println("check ./result.txt")
-	fmt.Println("DONE.")
+	fmt.Fprintln(os.Stderr, "DONE.")
 }